@@ -19,8 +19,10 @@ package client
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cockroachdb/cockroach/roachpb"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/encoding"
 )
 
@@ -44,7 +46,27 @@ type Batch struct {
 	//   _ = db.Run(b)
 	//   // string(b.Results[0].Rows[0].Key) == "a"
 	//   // string(b.Results[1].Rows[0].Key) == "b"
-	Results    []Result
+	//
+	// Reads and writes may be mixed freely, and the typed accessors of
+	// Result (Row, ValueBytes, ValueInt, ValueProto) return the value of
+	// single-row operations along with their error. If the batch fails
+	// because of one of its operations, that operation's result holds the
+	// error while the results of the others hold a NotAppliedError
+	// referring to it; otherwise all results hold the batch's error.
+	Results []Result
+	// ReadConsistency is the consistency with which the batch's reads are
+	// performed. An INCONSISTENT batch may only contain reads. They don't
+	// block on intents but return the most recent committed values, which
+	// may be stale. When run in a transaction, an INCONSISTENT batch is
+	// sent outside of it: its reads neither see the transaction's own
+	// writes nor affect its timestamp.
+	ReadConsistency roachpb.ReadConsistencyType
+	// Timeout, if positive, bounds the time the batch may take to run,
+	// for instance while waiting for an unavailable range. A batch which
+	// times out fails with context.DeadlineExceeded. This is unrelated to
+	// a transaction's deadline, which bounds its commit timestamp.
+	Timeout time.Duration
+
 	reqs       []roachpb.Request
 	resultsBuf [8]Result
 	rowsBuf    [8]KeyValue
@@ -57,9 +79,27 @@ func (b *Batch) prepare() error {
 			return err
 		}
 	}
+	if b.ReadConsistency != roachpb.CONSISTENT {
+		for _, args := range b.reqs {
+			if !roachpb.IsReadOnly(args) {
+				return util.Errorf("%s not permitted in %s batch", args.Method(), b.ReadConsistency)
+			}
+			args.Header().ReadConsistency = b.ReadConsistency
+		}
+	}
 	return nil
 }
 
+// isReadOnly returns true iff all of the batch's requests are reads.
+func (b *Batch) isReadOnly() bool {
+	for _, args := range b.reqs {
+		if !roachpb.IsReadOnly(args) {
+			return false
+		}
+	}
+	return true
+}
+
 func (b *Batch) initResult(calls, numRows int, err error) {
 	// TODO(tschottdorf): assert that calls is 0 or 1?
 	r := Result{calls: calls, Err: err}
@@ -77,7 +117,30 @@ func (b *Batch) initResult(calls, numRows int, err error) {
 	b.Results = append(b.Results, r)
 }
 
+// failedResult returns the index of the result whose operation caused
+// the given batch error, or -1 if the error can't be attributed to one.
+func (b *Batch) failedResult(err error) int {
+	iErr, ok := err.(roachpb.IndexedError)
+	if !ok {
+		return -1
+	}
+	index, ok := iErr.ErrorIndex()
+	if !ok {
+		return -1
+	}
+	offset := 0
+	for i, result := range b.Results {
+		offset += result.calls
+		if int(index) < offset {
+			return i
+		}
+	}
+	return -1
+}
+
 func (b *Batch) fillResults(br *roachpb.BatchResponse, pErr *roachpb.Error) error {
+	batchErr := pErr.GoError()
+	failed := b.failedResult(batchErr)
 	offset := 0
 	for i := range b.Results {
 		result := &b.Results[i]
@@ -87,7 +150,11 @@ func (b *Batch) fillResults(br *roachpb.BatchResponse, pErr *roachpb.Error) erro
 
 			var reply roachpb.Response
 			if result.Err == nil {
-				result.Err = pErr.GoError()
+				result.Err = batchErr
+				if result.Err != nil && failed >= 0 && failed != i {
+					// Only the failed operation gets the batch's error.
+					result.Err = &NotAppliedError{Index: failed, Cause: batchErr}
+				}
 				if result.Err == nil {
 					if offset+k < len(br.Responses) {
 						reply = br.Responses[offset+k].GetValue().(roachpb.Response)
@@ -160,6 +227,9 @@ func (b *Batch) fillResults(br *roachpb.BatchResponse, pErr *roachpb.Error) erro
 				row.Key = []byte(args.(*roachpb.DeleteRequest).Key)
 
 			case *roachpb.DeleteRangeRequest:
+				if result.Err == nil {
+					result.NumDeleted += reply.(*roachpb.DeleteRangeResponse).NumDeleted
+				}
 			case *roachpb.EndTransactionRequest:
 			case *roachpb.AdminMergeRequest:
 			case *roachpb.AdminSplitRequest:
@@ -228,6 +298,25 @@ func (b *Batch) Get(key interface{}) {
 	b.initResult(1, 1, nil)
 }
 
+// GetMulti retrieves the values for several keys. A single new result will be
+// appended to the batch which will contain a row for each key, in the order in
+// which the keys were given.
+//
+// key can be either a byte slice or a string.
+func (b *Batch) GetMulti(keys ...interface{}) {
+	var reqs []roachpb.Request
+	for _, key := range keys {
+		k, err := marshalKey(key)
+		if err != nil {
+			b.initResult(0, len(keys), err)
+			return
+		}
+		reqs = append(reqs, roachpb.NewGet(k))
+	}
+	b.reqs = append(b.reqs, reqs...)
+	b.initResult(len(reqs), len(reqs), nil)
+}
+
 // Put sets the value for a key.
 //
 // A new result will be appended to the batch which will contain a single row
@@ -250,6 +339,29 @@ func (b *Batch) Put(key, value interface{}) {
 	b.initResult(1, 1, nil)
 }
 
+// PutObject sets the value for a key to the gob encoding of obj, which
+// can be any value gob can encode, such as a struct. Use
+// KeyValue.ValueObject to decode it.
+//
+// A new result will be appended to the batch which will contain a single row
+// and Result.Err will indicate success or failure.
+//
+// key can be either a byte slice or a string.
+func (b *Batch) PutObject(key, obj interface{}) {
+	k, err := marshalKey(key)
+	if err != nil {
+		b.initResult(0, 1, err)
+		return
+	}
+	v, err := marshalObject(obj)
+	if err != nil {
+		b.initResult(0, 1, err)
+		return
+	}
+	b.reqs = append(b.reqs, roachpb.NewPut(k, v))
+	b.initResult(1, 1, nil)
+}
+
 // CPut conditionally sets the value for a key if the existing value is equal
 // to expValue. To conditionally set a value only if there is no existing entry
 // pass nil for expValue.
@@ -365,6 +477,13 @@ func (b *Batch) Del(keys ...interface{}) {
 //
 // key can be either a byte slice or a string.
 func (b *Batch) DelRange(s, e interface{}) {
+	b.DelRangeMax(s, e, 0)
+}
+
+// DelRangeMax is like DelRange, but deletes at most maxRows rows (all of
+// them if maxRows is zero). The number of rows deleted is returned in
+// Result.NumDeleted.
+func (b *Batch) DelRangeMax(s, e interface{}, maxRows int64) {
 	begin, err := marshalKey(s)
 	if err != nil {
 		b.initResult(0, 0, err)
@@ -375,7 +494,9 @@ func (b *Batch) DelRange(s, e interface{}) {
 		b.initResult(0, 0, err)
 		return
 	}
-	b.reqs = append(b.reqs, roachpb.NewDeleteRange(roachpb.Key(begin), roachpb.Key(end)))
+	req := roachpb.NewDeleteRange(roachpb.Key(begin), roachpb.Key(end)).(*roachpb.DeleteRangeRequest)
+	req.MaxEntriesToDelete = maxRows
+	b.reqs = append(b.reqs, req)
 	b.initResult(1, 0, nil)
 }
 
@@ -25,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"os"
 	"sync"
 	"testing"
@@ -238,6 +239,39 @@ func setTxnRetryBackoff(backoff time.Duration) func() {
 	}
 }
 
+// TestClientFailover verifies that a client given several node
+// addresses fails over from one which is down to a live one.
+func TestClientFailover(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := server.StartTestServer(t)
+	defer s.Stop()
+
+	// Grab an address nothing listens on.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := ln.Addr().String()
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := client.Open(s.Stopper(), fmt.Sprintf("rpcs://%s@%s,%s?certs=%s",
+		security.NodeUser, deadAddr, s.ServingAddr(), security.EmbeddedCertsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := fmt.Sprintf("%s/failover", testUser)
+	if err := db.Put(key, "value"); err != nil {
+		t.Fatal(err)
+	}
+	if gr, err := db.Get(key); err != nil {
+		t.Fatal(err)
+	} else if v := gr.ValueBytes(); !bytes.Equal(v, []byte("value")) {
+		t.Errorf("expected value; got %q", v)
+	}
+}
+
 // TestClientRunTransaction verifies some simple transaction isolation
 // semantics.
 func TestClientRunTransaction(t *testing.T) {
@@ -626,6 +660,167 @@ func TestConcurrentIncrements(t *testing.T) {
 	}
 }
 
+// TestTxnQueueConcurrentDequeue verifies that items enqueued
+// transactionally are each consumed exactly once by concurrent
+// dequeuers.
+func TestTxnQueueConcurrentDequeue(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	db := createTestClient(t, s.Stopper(), s.ServingAddr())
+
+	queue := roachpb.Key(testUser + "/queue")
+	const numItems = 20
+	if err := db.Txn(func(txn *client.Txn) error {
+		for i := 0; i < numItems; i++ {
+			if err := txn.Enqueue(queue, []byte(fmt.Sprintf("item-%02d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	const numDequeuers = 4
+	var mu sync.Mutex
+	consumed := map[string]int{}
+	var wg sync.WaitGroup
+	wg.Add(numDequeuers)
+	for i := 0; i < numDequeuers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var item []byte
+				var ok bool
+				if err := db.Txn(func(txn *client.Txn) error {
+					var err error
+					item, ok, err = txn.Dequeue(queue)
+					return err
+				}); err != nil {
+					t.Error(err)
+					return
+				}
+				if !ok {
+					return
+				}
+				mu.Lock()
+				consumed[string(item)]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(consumed) != numItems {
+		t.Errorf("expected %d items to be consumed; got %d", numItems, len(consumed))
+	}
+	for item, count := range consumed {
+		if count != 1 {
+			t.Errorf("item %q consumed %d times", item, count)
+		}
+	}
+}
+
+// TestTxnQueueSharedPrefix verifies that dequeuing from a queue leaves
+// alone the items and counter of a queue whose name extends its own.
+func TestTxnQueueSharedPrefix(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	db := createTestClient(t, s.Stopper(), s.ServingAddr())
+
+	jobs := roachpb.Key(testUser + "/jobs")
+	jobs2 := roachpb.Key(testUser + "/jobs2")
+	if err := db.Txn(func(txn *client.Txn) error {
+		if err := txn.Enqueue(jobs2, []byte("jobs2-item")); err != nil {
+			return err
+		}
+		return txn.Enqueue(jobs, []byte("jobs-item"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, exp := range []string{"jobs-item", ""} {
+		var item []byte
+		var ok bool
+		if err := db.Txn(func(txn *client.Txn) error {
+			var err error
+			item, ok, err = txn.Dequeue(jobs)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if ok != (exp != "") || string(item) != exp {
+			t.Errorf("%d: expected %q; got %q (ok=%t)", i, exp, item, ok)
+		}
+	}
+
+	// The other queue's counter and item are untouched.
+	if gr, err := db.Get(jobs2); err != nil {
+		t.Fatal(err)
+	} else if gr.ValueInt() != 1 {
+		t.Errorf("expected jobs2 counter to be 1; got %d", gr.ValueInt())
+	}
+	if err := db.Txn(func(txn *client.Txn) error {
+		item, ok, err := txn.Dequeue(jobs2)
+		if err == nil && (!ok || string(item) != "jobs2-item") {
+			t.Errorf("expected jobs2-item; got %q (ok=%t)", item, ok)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTxnSavepoint verifies that writes rolled back to a savepoint are
+// not committed, while earlier writes and committed values are kept.
+func TestTxnSavepoint(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := server.StartTestServer(t)
+	defer s.Stop()
+	db := createTestClient(t, s.Stopper(), s.ServingAddr())
+
+	keyA, keyB, keyC := testUser+"/sp-a", testUser+"/sp-b", testUser+"/sp-c"
+	if err := db.Put(keyC, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Txn(func(txn *client.Txn) error {
+		if err := txn.Put(keyA, "a"); err != nil {
+			return err
+		}
+		sp, err := txn.Savepoint()
+		if err != nil {
+			return err
+		}
+		if err := txn.Put(keyB, "b"); err != nil {
+			return err
+		}
+		if err := txn.Put(keyC, "new"); err != nil {
+			return err
+		}
+		return txn.RollbackToSavepoint(sp)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		key, expValue string
+	}{
+		{keyA, "a"},
+		{keyB, ""},
+		{keyC, "old"},
+	} {
+		gr, err := db.Get(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := string(gr.ValueBytes()); v != test.expValue {
+			t.Errorf("%s: expected %q; got %q", test.key, test.expValue, v)
+		}
+	}
+}
+
 // TestClientPermissions verifies permission enforcement.
 func TestClientPermissions(t *testing.T) {
 	defer leaktest.AfterTest(t)
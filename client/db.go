@@ -19,9 +19,11 @@ package client
 
 import (
 	"bytes"
+	"encoding/gob"
 	"fmt"
 	"math/rand"
 	"net/url"
+	"reflect"
 	"strconv"
 	"time"
 
@@ -29,6 +31,7 @@ import (
 
 	"github.com/cockroachdb/cockroach/base"
 	"github.com/cockroachdb/cockroach/roachpb"
+	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
 	"github.com/cockroachdb/cockroach/util/retry"
 	"github.com/cockroachdb/cockroach/util/stop"
@@ -131,6 +134,21 @@ func (kv *KeyValue) ValueProto(msg proto.Message) error {
 	return proto.Unmarshal(kv.Value.Bytes, msg)
 }
 
+// ValueObject decodes the gob-encoded byte slice value into obj, which
+// must be a pointer. obj is reset to its zero value first, and left that
+// way if there is no value.
+func (kv *KeyValue) ValueObject(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return util.Errorf("unable to decode value into non-pointer %T", obj)
+	}
+	v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	if kv.Value == nil || kv.Value.Bytes == nil {
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(kv.Value.Bytes)).Decode(obj)
+}
+
 // Result holds the result for a single DB or Txn operation (e.g. Get, Put,
 // etc).
 type Result struct {
@@ -143,6 +161,8 @@ type Result struct {
 	// rows returned is the number or rows matching the scan capped by the
 	// maxRows parameter. For DelRange Rows is nil.
 	Rows []KeyValue
+	// NumDeleted is the number of rows deleted by DelRange.
+	NumDeleted int64
 }
 
 func (r Result) String() string {
@@ -159,6 +179,64 @@ func (r Result) String() string {
 	return buf.String()
 }
 
+// Row returns the single row of a Get, Put, CPut, Inc or Del operation,
+// or the operation's error.
+func (r Result) Row() (KeyValue, error) {
+	if r.Err != nil {
+		return KeyValue{}, r.Err
+	}
+	if len(r.Rows) != 1 {
+		return KeyValue{}, util.Errorf("expected a single row; got %d", len(r.Rows))
+	}
+	return r.Rows[0], nil
+}
+
+// ValueBytes returns the value of the operation's single row as a byte
+// slice, or nil if the key doesn't exist.
+func (r Result) ValueBytes() ([]byte, error) {
+	row, err := r.Row()
+	if err != nil {
+		return nil, err
+	}
+	return row.ValueBytes(), nil
+}
+
+// ValueInt returns the value of the operation's single row decoded as
+// an int64, or 0 if the key doesn't exist. Unlike KeyValue.ValueInt, it
+// returns an error if the value can't be decoded.
+func (r Result) ValueInt() (int64, error) {
+	row, err := r.Row()
+	if err != nil || row.Value == nil {
+		return 0, err
+	}
+	return row.Value.GetInt()
+}
+
+// ValueProto parses the value of the operation's single row as a proto
+// message, resetting it if the key doesn't exist.
+func (r Result) ValueProto(msg proto.Message) error {
+	row, err := r.Row()
+	if err != nil {
+		return err
+	}
+	return row.ValueProto(msg)
+}
+
+// A NotAppliedError is the error of the operations of a batch which
+// failed only because another operation of the batch did. Batches are
+// applied atomically, so none of the batch's writes took effect.
+type NotAppliedError struct {
+	// Index is the index in Batch.Results of the failed operation.
+	Index int
+	// Cause is the error of the failed operation.
+	Cause error
+}
+
+// Error implements the error interface.
+func (e *NotAppliedError) Error() string {
+	return fmt.Sprintf("not applied: operation %d of the batch failed: %s", e.Index, e.Cause)
+}
+
 // DB is a database handle to a single cockroach cluster. A DB is safe for
 // concurrent use by multiple goroutines.
 type DB struct {
@@ -169,6 +247,13 @@ type DB struct {
 	// ignored.
 	userPriority    int32
 	txnRetryOptions retry.Options
+	// cmdIDFunc, if set, generates the client command IDs of write
+	// batches in place of newClientCmdID.
+	cmdIDFunc func() roachpb.ClientCmdID
+	// txnMetrics counts the transactions run on this DB. It is shared
+	// with the copies of the DB held by the transactions and only
+	// accessed atomically.
+	txnMetrics *TxnMetrics
 }
 
 // GetSender returns the underlying Sender. Only exported for tests.
@@ -181,6 +266,7 @@ func NewDB(sender Sender) *DB {
 	return &DB{
 		sender:          sender,
 		txnRetryOptions: DefaultTxnRetryOptions,
+		txnMetrics:      &TxnMetrics{},
 	}
 }
 
@@ -191,12 +277,26 @@ func NewDBWithPriority(sender Sender, userPriority int32) *DB {
 	return db
 }
 
+// SetCmdIDFunc overrides the generation of the client command IDs which
+// are assigned to batches containing writes, e.g. to make them
+// deterministic in tests or to correlate them with external request IDs.
+// A nil f restores the default, which combines the current time with a
+// random number. An ID is generated once per batch sent by the client:
+// it stays the same across the RPC retries performed by the underlying
+// sender, which is what makes them idempotent, but a transaction which
+// restarts issues its writes again with new IDs. Transactions inherit
+// the function from the DB they are run on. SetCmdIDFunc must not be
+// called concurrently with operations on db.
+func (db *DB) SetCmdIDFunc(f func() roachpb.ClientCmdID) {
+	db.cmdIDFunc = f
+}
+
 // TODO(pmattis): Allow setting the sender/txn retry options.
 
 // Open creates a new database handle to the cockroach cluster specified by
 // addr. The cluster is identified by a URL with the format:
 //
-//   [<sender>:]//[<user>@]<host>:<port>[?certs=<dir>,priority=<val>]
+//   [<sender>:]//[<user>@]<host>:<port>[,<host>:<port>...][?certs=<dir>,priority=<val>]
 //
 // The URL scheme (<sender>) specifies which transport to use for talking to
 // the cockroach cluster. Currently allowable values are: http, https, rpc,
@@ -207,6 +307,10 @@ func NewDBWithPriority(sender Sender, userPriority int32) *DB {
 // given cluster supports either encrypted or unencrypted traffic, but not
 // both.
 //
+// Several nodes of the cluster may be listed, in which case the rpc and rpcs
+// senders send to one of them and transparently fail over to the others when
+// it becomes unavailable.
+//
 // If not specified, the <user> field defaults to "root".
 //
 // The certs parameter can be used to override the default directory to use for
@@ -244,10 +348,7 @@ func Open(stopper *stop.Stopper, addr string) (*DB, error) {
 		return nil, fmt.Errorf("\"%s\" no sender specified", addr)
 	}
 
-	db := &DB{
-		sender:          sender,
-		txnRetryOptions: DefaultTxnRetryOptions,
-	}
+	db := NewDB(sender)
 
 	if priority := q["priority"]; len(priority) > 0 {
 		p, err := strconv.Atoi(priority[0])
@@ -279,6 +380,26 @@ func (db *DB) Get(key interface{}) (KeyValue, error) {
 	return runOneRow(db, b)
 }
 
+// GetMulti retrieves the values for several keys, returning the retrieved
+// key/values in the order in which the keys were given. The keys may belong
+// to different ranges: the lookups are sent as one batch, which is split into
+// a request per range, and the ranges are read in parallel.
+//
+// key can be either a byte slice or a string.
+func (db *DB) GetMulti(keys ...interface{}) ([]KeyValue, error) {
+	// The reads run in a transaction so that they're consistent with one
+	// another and can be sent to all ranges at once. A read-only
+	// transaction doesn't need to be committed, so it costs no extra
+	// round trip.
+	var rows []KeyValue
+	err := db.Txn(func(txn *Txn) error {
+		var err error
+		rows, err = txn.GetMulti(keys...)
+		return err
+	})
+	return rows, err
+}
+
 // GetProto retrieves the value for a key and decodes the result as a proto
 // message.
 //
@@ -291,6 +412,18 @@ func (db *DB) GetProto(key interface{}, msg proto.Message) error {
 	return r.ValueProto(msg)
 }
 
+// GetObject retrieves the value for a key and decodes the result, which
+// must have been written by PutObject, into obj.
+//
+// key can be either a byte slice or a string.
+func (db *DB) GetObject(key interface{}, obj interface{}) error {
+	r, err := db.Get(key)
+	if err != nil {
+		return err
+	}
+	return r.ValueObject(obj)
+}
+
 // Put sets the value for a key.
 //
 // key can be either a byte slice or a string. value can be any key type, a
@@ -302,6 +435,17 @@ func (db *DB) Put(key, value interface{}) error {
 	return err
 }
 
+// PutObject sets the value for a key to the gob encoding of obj, which
+// can be any value gob can encode, such as a struct.
+//
+// key can be either a byte slice or a string.
+func (db *DB) PutObject(key, obj interface{}) error {
+	b := db.NewBatch()
+	b.PutObject(key, obj)
+	_, err := runOneResult(db, b)
+	return err
+}
+
 // CPut conditionally sets the value for a key if the existing value is equal
 // to expValue. To conditionally set a value only if there is no existing entry
 // pass nil for expValue.
@@ -357,6 +501,22 @@ func (db *DB) ReverseScan(begin, end interface{}, maxRows int64) ([]KeyValue, er
 	return db.scan(begin, end, maxRows, true)
 }
 
+// ScanPage retrieves a page of the rows between begin (inclusive) and
+// end (exclusive) in ascending order. The page contains up to maxRows
+// rows, which must be positive; if maxBytes is positive, the page ends
+// early with the first row which brings the size of its keys and values
+// to maxBytes or more. maxBytes is applied by the client to the rows
+// returned by the server, so it trims the page but does not bound the
+// size of the server's response, which may hold up to maxRows rows.
+//
+// The returned resume key is the key at which to continue the scan to
+// retrieve the next page, and is nil once there are no more rows.
+//
+// key can be either a byte slice or a string.
+func (db *DB) ScanPage(begin, end interface{}, maxRows, maxBytes int64) ([]KeyValue, roachpb.Key, error) {
+	return scanPage(db, db.NewBatch(), begin, end, maxRows, maxBytes)
+}
+
 // Del deletes one or more keys.
 //
 // key can be either a byte slice or a string.
@@ -379,6 +539,18 @@ func (db *DB) DelRange(begin, end interface{}) error {
 	return err
 }
 
+// DelRangeMax deletes at most maxRows rows between begin (inclusive) and
+// end (exclusive), or all of them if maxRows is zero, and returns the
+// number of rows deleted.
+//
+// key can be either a byte slice or a string.
+func (db *DB) DelRangeMax(begin, end interface{}, maxRows int64) (int64, error) {
+	b := db.NewBatch()
+	b.DelRangeMax(begin, end, maxRows)
+	r, err := runOneResult(db, b)
+	return r.NumDeleted, err
+}
+
 // AdminMerge merges the range containing key and the subsequent
 // range. After the merge operation is complete, the range containing
 // key will contain all of the key/value pairs of the subsequent range
@@ -405,15 +577,25 @@ func (db *DB) AdminSplit(splitKey interface{}) error {
 // sendAndFill is a helper which sends the given batch and fills its results,
 // returning the appropriate error which is either from the first failing call,
 // or an "internal" error.
-func sendAndFill(send func(...roachpb.Request) (*roachpb.BatchResponse, *roachpb.Error), b *Batch) (*roachpb.BatchResponse, error) {
+func sendAndFill(ctx context.Context, send func(context.Context, ...roachpb.Request) (*roachpb.BatchResponse, *roachpb.Error), b *Batch) (*roachpb.BatchResponse, error) {
+	if b.Timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
 	// Errors here will be attached to the results, so we will get them from
 	// the call to fillResults in the regular case in which an individual call
 	// fails. But send() also returns its own errors, so there's some dancing
 	// here to do because we want to run fillResults() so that the individual
 	// result gets initialized with an error from the corresponding call.
-	br, pErr := send(b.reqs...)
+	br, pErr := send(ctx, b.reqs...)
 	if pErr != nil {
 		_ = b.fillResults(nil, pErr)
+		if err := ctx.Err(); err != nil {
+			// Report the timeout or cancellation rather than the error it
+			// caused, which doesn't retain its type on the way back.
+			return nil, err
+		}
 		return nil, pErr.GoError()
 	}
 	err := b.fillResults(br, nil)
@@ -440,42 +622,119 @@ func (db *DB) Run(b *Batch) error {
 	return err
 }
 
+// A Future is the handle of a batch run asynchronously by RunAsync.
+type Future struct {
+	b    *Batch
+	done chan struct{}
+	err  error
+}
+
+// Done returns a channel which is closed once the batch has run.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the batch has run and returns its results and the
+// error Run would have returned.
+func (f *Future) Wait() ([]Result, error) {
+	<-f.done
+	return f.b.Results, f.err
+}
+
+// RunAsync executes the operations queued up within a batch like Run,
+// but without waiting for them: the returned Future yields the results
+// once they are available. The batch must not be used until then, but
+// independent batches may be run concurrently this way.
+func (db *DB) RunAsync(b *Batch) *Future {
+	f := &Future{b: b, done: make(chan struct{})}
+	go func() {
+		f.err = db.Run(b)
+		close(f.done)
+	}()
+	return f
+}
+
 // RunWithResponse is a version of Run that returns the BatchResponse.
 func (db *DB) RunWithResponse(b *Batch) (*roachpb.BatchResponse, error) {
 	if err := b.prepare(); err != nil {
 		return nil, err
 	}
-	return sendAndFill(db.send, b)
+	return sendAndFill(context.TODO(), db.send, b)
 }
 
 // Txn executes retryable in the context of a distributed transaction. The
 // transaction is automatically aborted if retryable returns any error aside
 // from recoverable internal errors, and is automatically committed
 // otherwise. The retryable function should have no side effects which could
-// cause problems in the event it must be run more than once.
+// cause problems in the event it must be run more than once. If retryable
+// panics, the transaction is aborted before the panic is propagated to the
+// caller.
+//
+// To run work as part of an enclosing transaction, use Txn.Nested instead.
 //
 // TODO(pmattis): Allow transaction options to be specified.
 func (db *DB) Txn(retryable func(txn *Txn) error) error {
 	txn := NewTxn(*db)
 	txn.SetDebugName("", 1)
-	return txn.exec(retryable)
+	return txn.exec(TxnOptions{}, retryable)
+}
+
+// NewTxn returns a new transaction for callers which can't express their
+// work as a retryable function, such as a SQL session spanning several
+// statements. The caller must end the transaction with Commit or
+// Rollback, and handles retryable errors itself (see IsRetryableErr and
+// Txn.PrepareForRetry).
+func (db *DB) NewTxn() *Txn {
+	txn := NewTxn(*db)
+	txn.SetDebugName("", 1)
+	return txn
+}
+
+// TxnWithOptions is like Txn, but allows the execution of the
+// transaction to be customized through opts.
+func (db *DB) TxnWithOptions(opts TxnOptions, retryable func(txn *Txn) error) error {
+	txn := NewTxn(*db)
+	txn.SetDebugName("", 1)
+	return txn.exec(opts, retryable)
+}
+
+// TxnReturningTimestamp is like Txn, but also returns the timestamp at
+// which the transaction committed (see Txn.CommitTimestamp).
+func (db *DB) TxnReturningTimestamp(retryable func(txn *Txn) error) (roachpb.Timestamp, error) {
+	txn := NewTxn(*db)
+	txn.SetDebugName("", 1)
+	if err := txn.exec(TxnOptions{}, retryable); err != nil {
+		return roachpb.ZeroTimestamp, err
+	}
+	return txn.CommitTimestamp(), nil
 }
 
 // send runs the specified calls synchronously in a single batch and
 // returns any errors.
-func (db *DB) send(reqs ...roachpb.Request) (*roachpb.BatchResponse, *roachpb.Error) {
+func (db *DB) send(ctx context.Context, reqs ...roachpb.Request) (*roachpb.BatchResponse, *roachpb.Error) {
 	if len(reqs) == 0 {
 		return &roachpb.BatchResponse{}, nil
 	}
 
 	ba := roachpb.BatchRequest{}
 	ba.Add(reqs...)
+	// All requests in a batch share its read consistency.
+	ba.ReadConsistency = reqs[0].Header().ReadConsistency
 
 	if ba.UserPriority == nil && db.userPriority != 0 {
 		ba.UserPriority = proto.Int32(db.userPriority)
 	}
-	resetClientCmdID(&ba)
-	br, pErr := db.sender.Send(context.TODO(), ba)
+	if ba.IsWrite() {
+		if db.cmdIDFunc != nil {
+			ba.CmdID = db.cmdIDFunc()
+		} else {
+			ba.CmdID = newClientCmdID()
+		}
+	}
+	br, pErr := db.sender.Send(ctx, ba)
+	if br == nil && pErr == nil {
+		pErr = roachpb.NewError(util.Errorf("%T returned neither a response nor an error", db.sender))
+	}
 	if pErr != nil {
 		if log.V(1) {
 			log.Infof("failed batch: %s", pErr)
@@ -506,11 +765,53 @@ func runOneRow(r Runner, b *Batch) (KeyValue, error) {
 	return res.Rows[0], res.Err
 }
 
-// resetClientCmdID sets the client command ID if the call is for a
-// read-write method. The client command ID provides idempotency
-// protection in conjunction with the server.
-func resetClientCmdID(ba *roachpb.BatchRequest) {
-	ba.CmdID = roachpb.ClientCmdID{
+// scanPage implements ScanPage for DB and Txn, running the scan in the
+// given batch. The scan request only carries maxRows; the maxBytes cut
+// is made here, after the rows have been returned.
+func scanPage(r Runner, b *Batch, begin, end interface{}, maxRows, maxBytes int64) ([]KeyValue, roachpb.Key, error) {
+	if maxRows <= 0 {
+		return nil, nil, util.Errorf("page size must be positive; got %d rows", maxRows)
+	}
+	endKey, err := marshalKey(end)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.Scan(begin, endKey, maxRows)
+	res, err := runOneResult(r, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows := res.Rows
+	full := int64(len(rows)) == maxRows
+	if maxBytes > 0 {
+		var size int64
+		for i := range rows {
+			size += int64(len(rows[i].Key))
+			if rows[i].Value != nil {
+				size += int64(len(rows[i].Value.Bytes))
+			}
+			if size >= maxBytes {
+				full = full || i < len(rows)-1
+				rows = rows[:i+1]
+				break
+			}
+		}
+	}
+	if !full {
+		return rows, nil, nil
+	}
+	resume := roachpb.Key(rows[len(rows)-1].Key).Next()
+	if !resume.Less(endKey) {
+		return rows, nil, nil
+	}
+	return rows, resume, nil
+}
+
+// newClientCmdID returns a new client command ID. It is only used for
+// batches containing a read-write method. The client command ID provides
+// idempotency protection in conjunction with the server.
+func newClientCmdID() roachpb.ClientCmdID {
+	return roachpb.ClientCmdID{
 		WallTime: time.Now().UnixNano(),
 		Random:   rand.Int63(),
 	}
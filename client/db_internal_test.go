@@ -18,9 +18,16 @@
 package client
 
 import (
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/net/context"
 
 	"github.com/cockroachdb/cockroach/roachpb"
+	"github.com/cockroachdb/cockroach/testutils"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/leaktest"
 )
@@ -44,3 +51,399 @@ func TestClientCommandID(t *testing.T) {
 		t.Errorf("expected test sender to be invoked once; got %d", count)
 	}
 }
+
+// TestClientCommandIDReadOnly verifies that client command ID is not
+// set on read-only calls.
+func TestClientCommandIDReadOnly(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	count := 0
+	db := NewDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		count++
+		if !ba.CmdID.IsEmpty() {
+			return nil, roachpb.NewError(util.Errorf("expected no client command ID on read-only batch"))
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+	if _, err := db.Get("a"); err != nil {
+		t.Error(err)
+	}
+	if count != 1 {
+		t.Errorf("expected test sender to be invoked once; got %d", count)
+	}
+}
+
+// TestClientCommandIDFunc verifies that a custom command ID generator is
+// used for every write batch, including those sent by transactions, and
+// is not consulted for read-only batches.
+func TestClientCommandIDFunc(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var ids []int64
+	db := NewDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if !ba.CmdID.IsEmpty() {
+			ids = append(ids, ba.CmdID.Random)
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+	var next int64
+	db.SetCmdIDFunc(func() roachpb.ClientCmdID {
+		next++
+		return roachpb.ClientCmdID{WallTime: 1, Random: next}
+	})
+
+	if err := db.Put("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Txn(func(txn *Txn) error {
+		return txn.Put("b", "c")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The Put, then the transaction's Put and its EndTransaction.
+	if expIDs := []int64{1, 2, 3}; !reflect.DeepEqual(ids, expIDs) {
+		t.Errorf("expected command IDs %v; got %v", expIDs, ids)
+	}
+}
+
+// TestBatchTimeout verifies that a batch which takes longer than its
+// timeout fails with context.DeadlineExceeded, both on its own and
+// within a transaction, and that batches without a timeout aren't
+// affected.
+func TestBatchTimeout(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	wrapped := newTestSender(nil, nil)
+	db := NewDB(SenderFunc(func(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if _, ok := ba.GetArg(roachpb.Get); ok {
+			// Simulate an unavailable range.
+			if ctx.Done() == nil {
+				return nil, roachpb.NewError(util.Errorf("expected the batch to have a timeout"))
+			}
+			<-ctx.Done()
+			return nil, roachpb.NewError(util.Errorf("range unavailable"))
+		}
+		return wrapped.Send(ctx, ba)
+	}))
+
+	if err := db.Put("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	b := db.NewBatch()
+	b.Get("a")
+	b.Timeout = time.Millisecond
+	if err := db.Run(b); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded; got %v", err)
+	}
+	if err := db.Txn(func(txn *Txn) error {
+		b := txn.NewBatch()
+		b.Get("a")
+		b.Timeout = time.Millisecond
+		if err := txn.Run(b); err != context.DeadlineExceeded {
+			t.Errorf("expected context.DeadlineExceeded; got %v", err)
+		}
+		return txn.Put("a", "c")
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestScanPage verifies that paginated scans stop at the row and byte
+// limits and return the key at which to resume, until the end of the
+// scanned span has been reached.
+func TestScanPage(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var kvs []roachpb.KeyValue
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		kvs = append(kvs, roachpb.KeyValue{Key: roachpb.Key(k), Value: roachpb.Value{Bytes: []byte("xx")}})
+	}
+	db := NewDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		br := ba.CreateReply()
+		args := ba.Requests[0].GetInner().(*roachpb.ScanRequest)
+		reply := br.Responses[0].GetInner().(*roachpb.ScanResponse)
+		for _, kv := range kvs {
+			if !kv.Key.Less(args.Key) && kv.Key.Less(args.EndKey) && int64(len(reply.Rows)) < args.MaxResults {
+				reply.Rows = append(reply.Rows, kv)
+			}
+		}
+		return br, nil
+	}, nil))
+
+	testCases := []struct {
+		maxRows, maxBytes int64
+		expPages          []string
+	}{
+		{10, 0, []string{"abcde"}},
+		{5, 0, []string{"abcde"}},
+		{2, 0, []string{"ab", "cd", "e"}},
+		// Each row is three bytes.
+		{10, 7, []string{"abc", "de"}},
+		{10, 6, []string{"ab", "cd", "e"}},
+		{10, 1, []string{"a", "b", "c", "d", "e"}},
+		{2, 9, []string{"ab", "cd", "e"}},
+	}
+	for i, test := range testCases {
+		var pages []string
+		start := roachpb.Key("a")
+		for start != nil {
+			rows, resume, err := db.ScanPage(start, "e\x00", test.maxRows, test.maxBytes)
+			if err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			var page string
+			for _, kv := range rows {
+				page += string(kv.Key)
+			}
+			pages = append(pages, page)
+			start = resume
+		}
+		if !reflect.DeepEqual(pages, test.expPages) {
+			t.Errorf("%d: expected pages %v; got %v", i, test.expPages, pages)
+		}
+	}
+
+	if _, _, err := db.ScanPage("a", "z", 0, 0); err == nil {
+		t.Error("expected error for unbounded page")
+	}
+}
+
+type testWatcher struct {
+	Sender
+	start, end roachpb.Key
+	c          chan WatchEvent
+}
+
+func (w *testWatcher) Watch(_ context.Context, start, end roachpb.Key) (<-chan WatchEvent, error) {
+	w.start, w.end = start, end
+	return w.c, nil
+}
+
+// TestWatch verifies that DB.Watch passes the watched span through to
+// senders which support watches, and fails for those which don't.
+func TestWatch(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	w := &testWatcher{Sender: newTestSender(nil, nil), c: make(chan WatchEvent, 1)}
+	db := NewDB(w)
+	c, err := db.Watch(context.Background(), "a", roachpb.Key("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.start.Equal(roachpb.Key("a")) || !w.end.Equal(roachpb.Key("b")) {
+		t.Errorf("expected span [a,b); got [%s,%s)", w.start, w.end)
+	}
+	w.c <- WatchEvent{Key: roachpb.Key("a")}
+	if e := <-c; !e.Key.Equal(roachpb.Key("a")) {
+		t.Errorf("unexpected event %s", e)
+	}
+
+	db = NewDB(newTestSender(nil, nil))
+	if _, err := db.Watch(context.Background(), "a", "b"); !testutils.IsError(err, "does not support watches") {
+		t.Errorf("expected unsupported watch error; got %v", err)
+	}
+}
+
+// TestBatchMixedResults verifies that a batch mixing reads and writes
+// returns typed results in submission order, and that a failure is
+// attributed to the operation which caused it.
+func TestBatchMixedResults(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var failIndex int32 = -1
+	db := NewDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if failIndex >= 0 {
+			err := &roachpb.ConditionFailedError{}
+			err.SetErrorIndex(failIndex)
+			return nil, roachpb.NewError(err)
+		}
+		br := ba.CreateReply()
+		for i, union := range ba.Requests {
+			switch args := union.GetInner().(type) {
+			case *roachpb.GetRequest:
+				v := &roachpb.Value{}
+				v.SetBytes([]byte("v-" + string(args.Key)))
+				br.Responses[i].GetInner().(*roachpb.GetResponse).Value = v
+			case *roachpb.ScanRequest:
+				reply := br.Responses[i].GetInner().(*roachpb.ScanResponse)
+				reply.Rows = []roachpb.KeyValue{{Key: args.Key}, {Key: args.Key.Next()}}
+			}
+		}
+		return br, nil
+	}, nil))
+
+	b := db.NewBatch()
+	b.Get("a")
+	b.Put("b", 1)
+	b.CPut("c", "new", nil)
+	b.Scan("d", "e", 0)
+	b.Del("f")
+	if err := db.Run(b); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := b.Results[0].ValueBytes(); err != nil || string(v) != "v-a" {
+		t.Errorf("expected get to return v-a; got %q, %v", v, err)
+	}
+	if v, err := b.Results[1].ValueInt(); err != nil || v != 1 {
+		t.Errorf("expected put to return 1; got %d, %v", v, err)
+	}
+	if row, err := b.Results[2].Row(); err != nil || string(row.Key) != "c" {
+		t.Errorf("expected cput of c; got %s, %v", row.Key, err)
+	}
+	if rows := b.Results[3].Rows; len(rows) != 2 {
+		t.Errorf("expected 2 scanned rows; got %d", len(rows))
+	}
+	if _, err := b.Results[3].Row(); err == nil {
+		t.Error("expected error for the row of a scan")
+	}
+	if v, err := b.Results[4].ValueBytes(); err != nil || v != nil {
+		t.Errorf("expected deletion; got %q, %v", v, err)
+	}
+
+	failIndex = 2
+	b = db.NewBatch()
+	b.Get("a")
+	b.Put("b", 1)
+	b.CPut("c", "new", nil)
+	if err := db.Run(b); !testutils.IsError(err, "unexpected value") {
+		t.Fatalf("expected condition failed error; got %v", err)
+	}
+	for i, result := range b.Results {
+		if i == 2 {
+			if _, ok := result.Err.(*roachpb.ConditionFailedError); !ok {
+				t.Errorf("expected condition failed error for the cput; got %v", result.Err)
+			}
+		} else if nErr, ok := result.Err.(*NotAppliedError); !ok || nErr.Index != 2 {
+			t.Errorf("%d: expected not applied error; got %v", i, result.Err)
+		}
+	}
+}
+
+// TestPutGetObject verifies that values written with PutObject are
+// decoded by GetObject, and that GetObject resets the object for
+// missing keys.
+func TestPutGetObject(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	type object struct {
+		Name  string
+		Count int
+		Tags  []string
+	}
+	values := map[string]roachpb.Value{}
+	db := NewDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		br := ba.CreateReply()
+		switch args := ba.Requests[0].GetInner().(type) {
+		case *roachpb.PutRequest:
+			values[string(args.Key)] = args.Value
+		case *roachpb.GetRequest:
+			if v, ok := values[string(args.Key)]; ok {
+				br.Responses[0].GetInner().(*roachpb.GetResponse).Value = &v
+			}
+		}
+		return br, nil
+	}, nil))
+
+	exp := object{Name: "a", Count: 2, Tags: []string{"x", "y"}}
+	if err := db.PutObject("a", exp); err != nil {
+		t.Fatal(err)
+	}
+	var obj object
+	if err := db.GetObject("a", &obj); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(obj, exp) {
+		t.Errorf("expected %+v; got %+v", exp, obj)
+	}
+	if err := db.GetObject("b", &obj); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(obj, object{}) {
+		t.Errorf("expected zero object for missing key; got %+v", obj)
+	}
+	if err := db.GetObject("a", obj); err == nil {
+		t.Error("expected error decoding into non-pointer")
+	}
+}
+
+// TestRunAsync verifies that batches run with RunAsync execute
+// concurrently and that their futures yield their results.
+func TestRunAsync(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	const numBatches = 3
+	var wg sync.WaitGroup
+	wg.Add(numBatches)
+	db := NewDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		// Wait for all batches to be in flight at once.
+		wg.Done()
+		wg.Wait()
+		key := ba.Requests[0].GetInner().Header().Key
+		if key.Equal(roachpb.Key("b")) {
+			return nil, roachpb.NewError(util.Errorf("boom"))
+		}
+		br := ba.CreateReply()
+		v := &roachpb.Value{}
+		v.SetBytes(key)
+		br.Responses[0].GetInner().(*roachpb.GetResponse).Value = v
+		return br, nil
+	}, nil))
+
+	var futures []*Future
+	for _, k := range []string{"a", "b", "c"} {
+		b := db.NewBatch()
+		b.Get(k)
+		futures = append(futures, db.RunAsync(b))
+	}
+	for i, k := range []string{"a", "b", "c"} {
+		results, err := futures[i].Wait()
+		if k == "b" {
+			if !testutils.IsError(err, "boom") {
+				t.Errorf("%s: expected error; got %v", k, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", k, err)
+		}
+		if v, err := results[0].ValueBytes(); err != nil || string(v) != k {
+			t.Errorf("%s: unexpected value %q, %v", k, v, err)
+		}
+	}
+}
+
+// TestGetMulti verifies that GetMulti looks up all keys in a single
+// batch and returns the rows in the order of the keys.
+func TestGetMulti(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var batches int
+	db := NewDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		batches++
+		br := ba.CreateReply()
+		for i, union := range ba.Requests {
+			key := union.GetInner().Header().Key
+			if key.Equal(roachpb.Key("missing")) {
+				continue
+			}
+			v := &roachpb.Value{}
+			v.SetBytes([]byte("v-" + string(key)))
+			br.Responses[i].GetInner().(*roachpb.GetResponse).Value = v
+		}
+		return br, nil
+	}, nil))
+
+	keys := []interface{}{"c", "a", "missing", roachpb.Key("b")}
+	rows, err := db.GetMulti(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batches != 1 {
+		t.Errorf("expected a single batch; got %d", batches)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, fmt.Sprintf("%s=%s", row.Key, row.ValueBytes()))
+	}
+	if exp := []string{"c=v-c", "a=v-a", "missing=", "b=v-b"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v; got %v", exp, got)
+	}
+
+	if _, err := db.GetMulti("a", 1); err == nil {
+		t.Error("expected error for invalid key")
+	}
+}
@@ -23,7 +23,10 @@ import (
 	"strings"
 	"testing"
 
+	"golang.org/x/net/context"
+
 	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/roachpb"
 	"github.com/cockroachdb/cockroach/security"
 	"github.com/cockroachdb/cockroach/server"
 	"github.com/cockroachdb/cockroach/util/caller"
@@ -293,6 +296,41 @@ func ExampleDB_Insecure() {
 	// aa=1
 }
 
+func ExampleNewDB() {
+	// A DB can wrap any Sender, which makes it possible to exercise
+	// transactions against scripted responses. Here the first write of
+	// the transaction is refused with a retry error.
+	var puts int
+	db := client.NewDB(client.SenderFunc(func(_ context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		txn := ba.Txn.Clone()
+		txn.ID = []byte("txn")
+		if _, ok := ba.GetArg(roachpb.Put); ok {
+			if puts++; puts == 1 {
+				return nil, roachpb.NewError(&roachpb.TransactionRetryError{Txn: *txn})
+			}
+			txn.Writing = true
+		}
+		if args, ok := ba.GetArg(roachpb.EndTransaction); ok && args.(*roachpb.EndTransactionRequest).Commit {
+			txn.Status = roachpb.COMMITTED
+		}
+		br := ba.CreateReply()
+		br.Txn = txn
+		return br, nil
+	}))
+
+	var attempts int
+	if err := db.Txn(func(txn *client.Txn) error {
+		attempts++
+		return txn.Put("aa", "1")
+	}); err != nil {
+		panic(err)
+	}
+	fmt.Printf("attempts=%d\n", attempts)
+
+	// Output:
+	// attempts=2
+}
+
 func TestOpenArgs(t *testing.T) {
 	defer leaktest.AfterTest(t)
 	s := server.StartTestServer(t)
@@ -351,30 +389,62 @@ func TestCommonMethods(t *testing.T) {
 		// Batch.GetProto at the moment.
 		key{dbType, "GetProto"}:  {},
 		key{txnType, "GetProto"}: {},
+		// Similarly, there is no Batch.GetObject.
+		key{dbType, "GetObject"}:  {},
+		key{txnType, "GetObject"}: {},
+		// A page's resume key is only known once its results are in, so
+		// there is no Batch.ScanPage.
+		key{dbType, "ScanPage"}:  {},
+		key{txnType, "ScanPage"}: {},
 
 		key{batchType, "InternalAddRequest"}:      {},
 		key{dbType, "AdminMerge"}:                 {},
 		key{dbType, "AdminSplit"}:                 {},
 		key{dbType, "NewBatch"}:                   {},
+		key{dbType, "NewTxn"}:                     {},
 		key{dbType, "Run"}:                        {},
+		key{dbType, "RunAsync"}:                   {},
 		key{dbType, "RunWithResponse"}:            {},
+		key{dbType, "SetCmdIDFunc"}:               {},
 		key{dbType, "Txn"}:                        {},
+		key{dbType, "TxnMetrics"}:                 {},
+		key{dbType, "TxnReturningTimestamp"}:      {},
+		key{dbType, "TxnWithOptions"}:             {},
+		key{dbType, "Watch"}:                      {},
 		key{dbType, "GetSender"}:                  {},
+		key{txnType, "AbortIf"}:                   {},
+		key{txnType, "AbortWithReason"}:           {},
 		key{txnType, "Commit"}:                    {},
 		key{txnType, "CommitInBatch"}:             {},
 		key{txnType, "CommitInBatchWithResponse"}: {},
 		key{txnType, "CommitNoCleanup"}:           {},
+		key{txnType, "CommitTimestamp"}:           {},
 		key{txnType, "Rollback"}:                  {},
 		key{txnType, "Cleanup"}:                   {},
 		key{txnType, "DebugName"}:                 {},
+		key{txnType, "Dequeue"}:                   {},
+		key{txnType, "Enqueue"}:                   {},
+		key{txnType, "Epoch"}:                     {},
+		key{txnType, "GetForUpdate"}:              {},
+		key{txnType, "ID"}:                        {},
 		key{txnType, "InternalSetPriority"}:       {},
+		key{txnType, "Nested"}:                    {},
 		key{txnType, "NewBatch"}:                  {},
+		key{txnType, "PendingIntents"}:            {},
+		key{txnType, "PrepareForRetry"}:           {},
+		key{txnType, "Priority"}:                  {},
+		key{txnType, "RestartedFromScratch"}:      {},
+		key{txnType, "RollbackToSavepoint"}:       {},
 		key{txnType, "Run"}:                       {},
+		key{txnType, "RunParallel"}:               {},
 		key{txnType, "RunWithResponse"}:           {},
+		key{txnType, "Savepoint"}:                 {},
+		key{txnType, "SetDeadline"}:               {},
 		key{txnType, "SetDebugName"}:              {},
 		key{txnType, "SetIsolation"}:              {},
 		key{txnType, "SetSystemDBTrigger"}:        {},
 		key{txnType, "SystemDBTrigger"}:           {},
+		key{txnType, "Timestamp"}:                 {},
 	}
 
 	for b := range blacklist {
@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"strings"
+	"sync"
 
 	"golang.org/x/net/context"
 
@@ -36,7 +38,7 @@ import (
 func init() {
 	f := func(u *url.URL, ctx *base.Context, retryOpts retry.Options, stopper *stop.Stopper) (Sender, error) {
 		ctx.Insecure = (u.Scheme != "rpcs")
-		return newRPCSender(u.Host, ctx, retryOpts, stopper)
+		return newRPCSender(strings.Split(u.Host, ","), ctx, retryOpts, stopper)
 	}
 	RegisterSender("rpc", f)
 	RegisterSender("rpcs", f)
@@ -47,17 +49,26 @@ const method = "Server.Batch"
 // rpcSender is an implementation of Sender which exposes the
 // Key-Value database provided by a Cockroach cluster by connecting
 // via RPC to a Cockroach node. Overly-busy nodes will redirect this
-// client to other nodes.
+// client to other nodes. If given several nodes, the sender keeps a
+// connection to each and sends to one of them until it becomes
+// unhealthy or fails a request, at which point the sender fails over to
+// the next healthy one.
 type rpcSender struct {
-	client    *rpc.Client
+	mu        sync.Mutex
+	clients   []*rpc.Client
+	cur       int // Index of the client requests are sent to
 	retryOpts retry.Options
 }
 
 // newRPCSender returns a new instance of rpcSender.
-func newRPCSender(server string, context *base.Context, retryOpts retry.Options, stopper *stop.Stopper) (*rpcSender, error) {
-	addr, err := net.ResolveTCPAddr("tcp", server)
-	if err != nil {
-		return nil, err
+func newRPCSender(servers []string, context *base.Context, retryOpts retry.Options, stopper *stop.Stopper) (*rpcSender, error) {
+	var addrs []net.Addr
+	for _, server := range servers {
+		addr, err := net.ResolveTCPAddr("tcp", server)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
 	}
 
 	if context.Insecure {
@@ -69,11 +80,39 @@ func newRPCSender(server string, context *base.Context, retryOpts retry.Options,
 	}
 
 	ctx := rpc.NewContext(context, hlc.NewClock(hlc.UnixNano), stopper)
-	client := rpc.NewClient(addr, ctx)
-	return &rpcSender{
-		client:    client,
-		retryOpts: retryOpts,
-	}, nil
+	s := &rpcSender{retryOpts: retryOpts}
+	for _, addr := range addrs {
+		s.clients = append(s.clients, rpc.NewClient(addr, ctx))
+	}
+	return s, nil
+}
+
+// healthyClient returns the current client if it's healthy, and
+// otherwise fails over to the next healthy client. Returns nil if no
+// client is healthy.
+func (s *rpcSender) healthyClient() *rpc.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.clients {
+		idx := (s.cur + i) % len(s.clients)
+		select {
+		case <-s.clients[idx].Healthy():
+			s.cur = idx
+			return s.clients[idx]
+		default:
+		}
+	}
+	return nil
+}
+
+// failover moves on from the given client, which failed a request, to
+// the next one, unless that already happened concurrently.
+func (s *rpcSender) failover(client *rpc.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[s.cur] == client {
+		s.cur = (s.cur + 1) % len(s.clients)
+	}
 }
 
 // Batch sends a request to Cockroach via RPC. Errors which are retryable are
@@ -86,15 +125,14 @@ func (s *rpcSender) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb
 	var err error
 	var br roachpb.BatchResponse
 	for r := retry.Start(s.retryOpts); r.Next(); {
-		select {
-		case <-s.client.Healthy():
-		default:
+		client := s.healthyClient()
+		if client == nil {
 			err = fmt.Errorf("failed to send RPC request %s: client is unhealthy", method)
 			log.Warning(err)
 			continue
 		}
 
-		if err = s.client.Call(method, &ba, &br); err != nil {
+		if err = client.Call(method, &ba, &br); err != nil {
 			br.Reset() // don't trust anyone.
 			// Assume all errors sending request are retryable. The actual
 			// number of things that could go wrong is vast, but we don't
@@ -103,7 +141,11 @@ func (s *rpcSender) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb
 			// there's visiblity that this is happening. Some of the errors
 			// we'll sweep up in this net shouldn't be retried, but we can't
 			// really know for sure which.
+			// Since the same batch is retried with the same client command
+			// ID, it's safe to send it to another node even if it went
+			// through on this one.
 			log.Warningf("failed to send RPC request %s: %s", method, err)
+			s.failover(client)
 			continue
 		}
 
@@ -19,6 +19,8 @@ package client
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
@@ -26,18 +28,24 @@ import (
 	"github.com/cockroachdb/cockroach/roachpb"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/caller"
+	"github.com/cockroachdb/cockroach/util/encoding"
 	"github.com/cockroachdb/cockroach/util/log"
 	"github.com/cockroachdb/cockroach/util/retry"
+	"github.com/cockroachdb/cockroach/util/tracer"
+	"github.com/cockroachdb/cockroach/util/uuid"
 	"github.com/gogo/protobuf/proto"
 )
 
 // DefaultTxnRetryOptions are the standard retry options used
-// for transactions.
+// for transactions. The backoff is randomized by up to 25% in either
+// direction so that transactions contending on the same keys don't
+// retry in lockstep.
 // This is exported for testing purposes only.
 var DefaultTxnRetryOptions = retry.Options{
-	InitialBackoff: 50 * time.Millisecond,
-	MaxBackoff:     5 * time.Second,
-	Multiplier:     2,
+	InitialBackoff:      50 * time.Millisecond,
+	MaxBackoff:          5 * time.Second,
+	Multiplier:          2,
+	RandomizationFactor: 0.25,
 }
 
 // txnSender implements the Sender interface and is used to keep the Send
@@ -45,9 +53,45 @@ var DefaultTxnRetryOptions = retry.Options{
 type txnSender Txn
 
 func (ts *txnSender) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+	if ts.readTimestamp != roachpb.ZeroTimestamp {
+		ts.protoMu.Lock()
+		ts.pinReadTimestamp()
+		ts.protoMu.Unlock()
+	}
+	if ts.parallelReads {
+		// Read-only batches of a transaction which has already begun are
+		// sent concurrently with each other against a snapshot of the
+		// transaction. Anything else is sent alone. In either case the
+		// transaction returned in the response is merged into Proto below.
+		// The status checked by Txn.send may be stale by the time sendMu
+		// is acquired if a concurrent commit got there first, so it is
+		// checked again: a commit holds sendMu exclusively until it has
+		// finalized Proto, and so waits for every read admitted here.
+		unlock := ts.lockForSend(ba.IsReadOnly())
+		defer unlock()
+		ts.protoMu.Lock()
+		status := ts.Proto.Status
+		ba.Txn = ts.Proto.Clone()
+		ts.protoMu.Unlock()
+		if status != roachpb.PENDING {
+			return nil, newTxnFinalizedError(*ba.Txn, ts.abortReason)
+		}
+	} else {
+		ba.Txn = &ts.Proto
+	}
+	var trace *tracer.Trace
+	if ts.tracer != nil {
+		trace = ts.tracer.NewTrace(&ba)
+		defer trace.Finalize()
+		defer trace.Epoch(fmt.Sprintf("%s (epoch %d, attempt %d)", ba.Methods(), ba.Txn.Epoch, ts.attempt))()
+		ctx = tracer.ToCtx(ctx, trace)
+	}
 	// Send call through wrapped sender.
-	ba.Txn = &ts.Proto
 	br, pErr := ts.wrapped.Send(ctx, ba)
+	if br == nil && pErr == nil {
+		// Don't let a misbehaving sender crash the process below.
+		pErr = roachpb.NewError(util.Errorf("%T returned neither a response nor an error", ts.wrapped))
+	}
 	if br != nil && br.Error != nil {
 		panic(roachpb.ErrorUnexpectedlySet(ts.wrapped, br))
 	}
@@ -55,37 +99,373 @@ func (ts *txnSender) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachp
 	// TODO(tschottdorf): see about using only the top-level *roachpb.Error
 	// information for this restart logic (includes adding the Txn).
 	err := pErr.GoError()
+	if trace != nil {
+		if restart, immediate := isRetryableErr(err); restart && !immediate {
+			trace.Event(fmt.Sprintf("backing off: %s", err))
+		}
+	}
 	// Only successful requests can carry an updated Txn in their response
 	// header. Any error (e.g. a restart) can have a Txn attached to them as
 	// well; those update our local state in the same way for the next attempt.
 	// The exception is if our transaction was aborted and needs to restart
 	// from scratch, in which case we do just that.
+	ts.protoMu.Lock()
+	defer ts.protoMu.Unlock()
+	defer ts.pinReadTimestamp()
 	if err == nil {
 		ts.Proto.Update(br.Txn)
 		return br, nil
 	} else if abrtErr, ok := err.(*roachpb.TransactionAbortedError); ok {
-		// On Abort, reset the transaction so we start anew on restart.
+		// On Abort, reset the transaction so we start anew on restart. The
+		// priority acts as a minimum on restart; it is chosen by the
+		// priority policy based on the aborted transaction
+		// (TransactionAbortedError.Transaction() is always nil).
+		policy := ts.priorityPolicy
+		if policy == nil {
+			policy = MatchConflicting
+		}
 		ts.Proto = roachpb.Transaction{
 			Name:      ts.Proto.Name,
+			Key:       ts.anchorKey,
 			Isolation: ts.Proto.Isolation,
-			// Acts as a minimum priority on restart.
-			Priority: abrtErr.Transaction().GetPriority(),
+			Priority:  policy(ts.Proto.Priority, abrtErr.Txn.Priority),
 		}
 	} else if txnErr, ok := err.(roachpb.TransactionRestartError); ok {
 		ts.Proto.Update(txnErr.Transaction())
+		if _, ok := err.(*roachpb.TransactionRetryError); ok && ts.Proto.Isolation == roachpb.SERIALIZABLE {
+			ts.retryPending = true
+		}
 	}
 	return nil, pErr
 }
 
+// pinReadTimestamp resets the transaction's timestamps to readTimestamp,
+// if set, undoing any forwarding by restarts or responses. It must be
+// called with protoMu held.
+func (ts *txnSender) pinReadTimestamp() {
+	if ts.readTimestamp == roachpb.ZeroTimestamp {
+		return
+	}
+	ts.Proto.Timestamp = ts.readTimestamp
+	ts.Proto.OrigTimestamp = ts.readTimestamp
+	ts.Proto.MaxTimestamp = ts.readTimestamp
+}
+
+// lockForSend acquires sendMu, shared if readOnly and the transaction
+// has already begun (and thus has an ID which concurrent requests agree
+// on), exclusively otherwise. It returns a function which releases it.
+func (ts *txnSender) lockForSend(readOnly bool) func() {
+	if readOnly {
+		ts.sendMu.RLock()
+		ts.protoMu.Lock()
+		begun := len(ts.Proto.ID) > 0
+		ts.protoMu.Unlock()
+		if begun {
+			return ts.sendMu.RUnlock
+		}
+		ts.sendMu.RUnlock()
+	}
+	ts.sendMu.Lock()
+	return ts.sendMu.Unlock
+}
+
+// A PriorityPolicy returns the priority with which a transaction is
+// restarted after it has been aborted, given its current priority and
+// the priority of the aborted transaction, which has usually been
+// raised to just below that of the transaction which aborted it.
+type PriorityPolicy func(current, aborted int32) int32
+
+// MatchConflicting is the default PriorityPolicy. The transaction is
+// restarted with the higher of the two priorities, so that repeatedly
+// aborted transactions eventually win their conflicts.
+func MatchConflicting(current, aborted int32) int32 {
+	if aborted > current {
+		return aborted
+	}
+	return current
+}
+
+// NoEscalation is a PriorityPolicy which restarts transactions with
+// their current priority.
+func NoEscalation(current, aborted int32) int32 {
+	return current
+}
+
+// CappedPriority returns a PriorityPolicy which behaves like
+// MatchConflicting but doesn't escalate beyond max.
+func CappedPriority(max int32) PriorityPolicy {
+	return func(current, aborted int32) int32 {
+		if p := MatchConflicting(current, aborted); p < max {
+			return p
+		}
+		if current > max {
+			return current
+		}
+		return max
+	}
+}
+
+// TxnOptions holds options which customize the execution of a
+// transaction via DB.TxnWithOptions. The zero value yields the default
+// behavior of DB.Txn.
+type TxnOptions struct {
+	// HeartbeatInterval, if non-zero, starts a goroutine which sends a
+	// HeartbeatTxn request for the transaction at the given interval
+	// until the transaction commits or aborts. The transaction
+	// coordinator considers a transaction abandoned when it has not
+	// received a request from the client for some time; heartbeating
+	// keeps the transaction alive while retryable performs lengthy work
+	// between requests. Heartbeats are only sent once the transaction
+	// has begun writing, since read-only transactions have no
+	// transaction record.
+	HeartbeatInterval time.Duration
+	// ParallelReads allows read-only operations (e.g. Get and Scan) to be
+	// issued concurrently from multiple goroutines within retryable. Each
+	// is sent against a snapshot of the transaction and the resulting
+	// timestamps are merged back in. Writes and commits are still sent
+	// one at a time and must not be issued concurrently with each other.
+	ParallelReads bool
+	// Deadline, if non-zero, is the latest timestamp at which the
+	// transaction may commit. If the transaction's timestamp has been
+	// pushed past it, the commit fails with a
+	// *TransactionDeadlineExceededError and the transaction is aborted
+	// rather than retried. The deadline is checked by the client before
+	// the commit is sent, so a SNAPSHOT transaction whose record was
+	// pushed by a concurrent reader may still commit past it: the client
+	// only learns of such a push from the commit's response.
+	Deadline roachpb.Timestamp
+	// ReadTimestamp, if non-zero, makes the transaction read-only and
+	// fixes the timestamp at which it reads, for instance to run a
+	// consistent read of historical data. The timestamp isn't advanced
+	// on restarts and there is no uncertainty interval, so it should be
+	// further in the past than the maximum clock offset. Writes are
+	// rejected.
+	ReadTimestamp roachpb.Timestamp
+	// OnRetry, if non-nil, is invoked before each retry of retryable
+	// with the number of the attempt which failed, starting at 1, and
+	// the error which caused the retry (e.g. a *TransactionRetryError
+	// or *TransactionAbortedError; CommitErrors are unwrapped).
+	OnRetry func(attempt int, cause error)
+	// PriorityPolicy, if non-nil, determines the priority of the
+	// transaction when it is restarted after being aborted. The default
+	// is MatchConflicting.
+	PriorityPolicy PriorityPolicy
+	// Tracer, if non-nil, traces every batch sent by the transaction.
+	// Each trace records the methods of the batch along with the epoch
+	// and attempt of the transaction, notes when the batch caused the
+	// transaction to back off before retrying, and is passed on to the
+	// wrapped sender via the context.
+	Tracer *tracer.Tracer
+	// Context, if non-nil, is passed to the wrapped sender with every
+	// batch of the transaction. Once it is done, the transaction's
+	// operations fail, it is no longer retried and instead aborted, and
+	// the context's error is returned.
+	Context context.Context
+	// RetryOptions, if non-nil, replace the DB's retry options (which
+	// default to DefaultTxnRetryOptions) for the backoff and jitter
+	// applied to restarts of the transaction. MaxRetries bounds the
+	// number of consecutive restarts which back off; once exhausted, the
+	// error which caused the last restart is returned. Restarts which
+	// retry immediately (see TransactionRestart) reset the count.
+	RetryOptions *retry.Options
+	// MaxRestarts, if positive, bounds the total number of restarts of
+	// the transaction, whether they back off or not. Once exhausted, the
+	// transaction is aborted and the error which caused the last attempt
+	// to fail is returned.
+	MaxRestarts int
+	// ReadOnly makes the transaction reject writes. A transaction which
+	// doesn't write never creates a transaction record, and committing
+	// it doesn't send an EndTransaction, so its reads are all it costs.
+	// Setting ReadOnly makes sure it stays that way. See ReadTimestamp
+	// to also fix the timestamp at which it reads.
+	ReadOnly bool
+	// AnchorKey, if set, is the key at which the transaction record is
+	// anchored, in place of the key of the transaction's first request.
+	// The anchor determines the range which holds the transaction record
+	// and so receives its heartbeats, pushes and commit; choosing a key
+	// on a less contended range avoids a bottleneck there. The key is
+	// used again when the transaction is restarted after an abort.
+	AnchorKey roachpb.Key
+	// MaxIntents and MaxIntentBytes, if positive, bound the number of
+	// transactional writes sent during an attempt of the transaction and
+	// the total size of their keys and values, respectively. A batch
+	// which would exceed either fails with a *TransactionTooLargeError
+	// without being sent. The error isn't retryable, so the transaction
+	// is aborted unless the transaction function handles it.
+	MaxIntents     int
+	MaxIntentBytes int64
+}
+
 // Txn is an in-progress distributed database transaction. A Txn is not safe for
 // concurrent use by multiple goroutines.
 type Txn struct {
 	db      DB
 	wrapped Sender
+	// protoMu protects Proto from concurrent access by the heartbeat
+	// goroutine and by parallel reads. Proto is otherwise only written from
+	// the goroutine which owns the Txn.
+	protoMu sync.Mutex
 	Proto   roachpb.Transaction
+	// parallelReads is set via TxnOptions.ParallelReads. When set, sendMu
+	// is held shared by read-only requests and exclusively by all others.
+	parallelReads bool
+	sendMu        sync.RWMutex
+	// deadline is set via TxnOptions.Deadline or SetDeadline and checked
+	// before the commit is sent.
+	deadline *roachpb.Timestamp
+	// readOnly is set via TxnOptions.ReadOnly.
+	readOnly bool
+	// readTimestamp is set via TxnOptions.ReadTimestamp.
+	readTimestamp roachpb.Timestamp
+	// priorityPolicy is set via TxnOptions.PriorityPolicy.
+	priorityPolicy PriorityPolicy
+	// tracer is set via TxnOptions.Tracer.
+	tracer *tracer.Tracer
+	// ctx is set via TxnOptions.Context.
+	ctx context.Context
+	// anchorKey is set via TxnOptions.AnchorKey.
+	anchorKey roachpb.Key
+	// attempt is the number of the current attempt of exec, starting at
+	// 1. It is zero for a transaction not run through exec.
+	attempt int
+	// retryPending is set when a request of a SERIALIZABLE transaction
+	// fails with a TransactionRetryError, which must cause the current
+	// attempt to be retried. It is protected by protoMu.
+	retryPending bool
+	// abortReason is set by AbortIf to abort rather than commit the
+	// current attempt of the transaction, and by AbortWithReason. Once
+	// the transaction is aborted, it is reported by the errors returned
+	// for any further use of the transaction.
+	abortReason *string
+	// fromScratch is set by exec when the current attempt follows an
+	// abort of the previous one. See RestartedFromScratch.
+	fromScratch bool
 	// systemDBTrigger is set to true when modifying keys from the
 	// SystemDB span. This sets the SystemDBTrigger on EndTransactionRequest.
 	systemDBTrigger bool
+	// heartbeatStop is closed to stop the heartbeat goroutine, which in
+	// turn closes heartbeatDone when it exits. Both are nil unless a
+	// heartbeat was started.
+	heartbeatStop, heartbeatDone chan struct{}
+	// start is the time at which the transaction was created.
+	start time.Time
+	// writes holds the key spans of the transactional writes sent during
+	// the current attempt, in order. It is used to roll back to savepoints
+	// and reported in CommitError.
+	writes []writeSpan
+	// writeBytes is the total size of writes.
+	writeBytes int64
+	// maxIntents and maxIntentBytes are set via TxnOptions.
+	maxIntents     int
+	maxIntentBytes int64
+}
+
+// writeSpan is the key span of a transactional write. endKey is nil for
+// writes to a single key. size is the size of the write's keys and
+// value.
+type writeSpan struct {
+	key, endKey roachpb.Key
+	size        int64
+}
+
+// writeSize returns the size of the keys and value of a transactional
+// write, as counted against TxnOptions.MaxIntentBytes.
+func writeSize(args roachpb.Request) int64 {
+	h := args.Header()
+	size := len(h.Key) + len(h.EndKey)
+	switch t := args.(type) {
+	case *roachpb.PutRequest:
+		size += len(t.Value.Bytes)
+	case *roachpb.ConditionalPutRequest:
+		size += len(t.Value.Bytes)
+	}
+	return int64(size)
+}
+
+// TransactionTooLargeError is returned when a batch would take a
+// transaction past the budget set via TxnOptions.MaxIntents or
+// TxnOptions.MaxIntentBytes.
+type TransactionTooLargeError struct {
+	// Intents and IntentBytes are the number and size of the writes the
+	// transaction would have had with the batch.
+	Intents     int
+	IntentBytes int64
+	// MaxIntents and MaxIntentBytes are the budget.
+	MaxIntents     int
+	MaxIntentBytes int64
+}
+
+// Error implements the error interface.
+func (e *TransactionTooLargeError) Error() string {
+	return fmt.Sprintf("transaction too large: %d intents (%d bytes) exceed budget of %d intents (%d bytes)",
+		e.Intents, e.IntentBytes, e.MaxIntents, e.MaxIntentBytes)
+}
+
+// NonTransactionalMethodError is returned when a batch run through a
+// transaction contains a command which can't be carried out
+// transactionally, such as an admin command.
+type NonTransactionalMethodError struct {
+	Method roachpb.Method
+}
+
+// Error implements the error interface.
+func (e *NonTransactionalMethodError) Error() string {
+	return fmt.Sprintf("method %s cannot be invoked through a transaction", e.Method)
+}
+
+// newTxnFinalizedError returns the error with which requests sent
+// through a transaction fail once it has been committed or aborted: a
+// TransactionStatusError carrying the transaction and its status, and
+// the reason it was aborted with, if any.
+func newTxnFinalizedError(txn roachpb.Transaction, abortReason *string) *roachpb.Error {
+	msg := fmt.Sprintf("attempting to use %s transaction", txn.Status)
+	if txn.Status == roachpb.ABORTED && abortReason != nil {
+		msg = fmt.Sprintf("%s (aborted: %s)", msg, *abortReason)
+	}
+	return roachpb.NewError(roachpb.NewTransactionStatusError(txn, msg))
+}
+
+// TransactionDeadlineExceededError is returned when committing a
+// transaction whose timestamp has been pushed past the deadline set via
+// TxnOptions.Deadline or Txn.SetDeadline.
+type TransactionDeadlineExceededError struct {
+	Timestamp roachpb.Timestamp
+	Deadline  roachpb.Timestamp
+}
+
+// Error implements the error interface.
+func (e *TransactionDeadlineExceededError) Error() string {
+	return fmt.Sprintf("transaction timestamp %s exceeds commit deadline %s", e.Timestamp, e.Deadline)
+}
+
+// CommitError wraps an error returned when committing a transaction
+// with information about the transaction that's useful in diagnosing
+// the failure. The original error is available as Cause.
+type CommitError struct {
+	Cause     error
+	TxnID     []byte
+	Epoch     int32
+	Timestamp roachpb.Timestamp
+	// Writes is the number of transactional writes sent during the
+	// final attempt.
+	Writes int
+	// Elapsed is the time since the transaction was created.
+	Elapsed time.Duration
+}
+
+// Error implements the error interface.
+func (e *CommitError) Error() string {
+	return fmt.Sprintf("commit of txn %s failed (epoch=%d ts=%s writes=%d elapsed=%s): %s",
+		uuid.UUID(e.TxnID).Short(), e.Epoch, e.Timestamp, e.Writes, e.Elapsed, e.Cause)
+}
+
+// unwrapCommitError returns the original error if err is a
+// CommitError and err otherwise.
+func unwrapCommitError(err error) error {
+	if cErr, ok := err.(*CommitError); ok {
+		return cErr.Cause
+	}
+	return err
 }
 
 // NewTxn returns a new txn.
@@ -93,8 +473,10 @@ func NewTxn(db DB) *Txn {
 	txn := &Txn{
 		db:      db,
 		wrapped: db.sender,
+		start:   time.Now(),
 	}
 	txn.db.sender = (*txnSender)(txn)
+	atomic.AddInt64(&txn.db.txnMetrics.Started, 1)
 	return txn
 }
 
@@ -115,10 +497,63 @@ func (txn *Txn) DebugName() string {
 	return txn.Proto.Name
 }
 
+// RestartedFromScratch returns whether the current attempt of the
+// transaction restarted from scratch. That is the case when the
+// previous attempt was aborted: the transaction then begins anew with a
+// new identity and none of the previous attempt's writes survive. Other
+// retries (for instance after a TransactionRetryError) restart the
+// transaction in place at a higher epoch; its earlier intents remain
+// until they are overwritten or the transaction ends. In either case
+// savepoints taken during the previous attempt are invalid. It returns
+// false during the first attempt.
+func (txn *Txn) RestartedFromScratch() bool {
+	return txn.fromScratch
+}
+
+// Priority returns the transaction's current priority. The priority is
+// ratcheted up each time the transaction is aborted and restarted, so
+// after a transaction has run it reflects the priority of the final
+// attempt.
+func (txn *Txn) Priority() int32 {
+	txn.protoMu.Lock()
+	defer txn.protoMu.Unlock()
+	return txn.Proto.Priority
+}
+
+// ID returns the transaction's ID, which is empty until the transaction
+// has sent its first request. A transaction which is aborted and
+// restarted from scratch gets a new ID; other restarts keep it.
+func (txn *Txn) ID() []byte {
+	txn.protoMu.Lock()
+	defer txn.protoMu.Unlock()
+	return txn.Proto.ID
+}
+
+// Epoch returns the transaction's epoch, which is incremented each time
+// the transaction is restarted without being aborted.
+func (txn *Txn) Epoch() int32 {
+	txn.protoMu.Lock()
+	defer txn.protoMu.Unlock()
+	return txn.Proto.Epoch
+}
+
+// Timestamp returns the transaction's current timestamp. Its writes are
+// performed at this timestamp, which may be pushed forward by conflicts
+// with other transactions. See CommitTimestamp for the timestamp at
+// which a transaction committed.
+func (txn *Txn) Timestamp() roachpb.Timestamp {
+	txn.protoMu.Lock()
+	defer txn.protoMu.Unlock()
+	return txn.Proto.Timestamp
+}
+
 // SetIsolation sets the transaction's isolation type. Transactions default to
 // serializable isolation. The isolation must be set before any operations are
 // performed on the transaction.
 func (txn *Txn) SetIsolation(isolation roachpb.IsolationType) error {
+	if _, ok := roachpb.IsolationType_name[int32(isolation)]; !ok {
+		return fmt.Errorf("unknown isolation type %d", isolation)
+	}
 	if txn.Proto.Isolation != isolation {
 		if txn.Proto.IsInitialized() {
 			return fmt.Errorf("cannot change the isolation level of a running transaction")
@@ -128,6 +563,17 @@ func (txn *Txn) SetIsolation(isolation roachpb.IsolationType) error {
 	return nil
 }
 
+// SetDeadline sets the latest timestamp at which the transaction may
+// commit, as with TxnOptions.Deadline. It can be called while the
+// transaction is running, e.g. once the expiration of a lease on state
+// read by the transaction is known. A deadline can only be tightened:
+// if one is already set, the earlier of the two applies.
+func (txn *Txn) SetDeadline(deadline roachpb.Timestamp) {
+	if txn.deadline == nil || deadline.Less(*txn.deadline) {
+		txn.deadline = &deadline
+	}
+}
+
 // InternalSetPriority sets the transaction priority. It is intended for
 // internal (testing) use only.
 func (txn *Txn) InternalSetPriority(priority int32) {
@@ -165,6 +611,17 @@ func (txn *Txn) Get(key interface{}) (KeyValue, error) {
 	return runOneRow(txn, b)
 }
 
+// GetMulti retrieves the values for several keys, returning the retrieved
+// key/values in the order in which the keys were given.
+//
+// key can be either a byte slice or a string.
+func (txn *Txn) GetMulti(keys ...interface{}) ([]KeyValue, error) {
+	b := txn.NewBatch()
+	b.GetMulti(keys...)
+	r, err := runOneResult(txn, b)
+	return r.Rows, err
+}
+
 // GetProto retrieves the value for a key and decodes the result as a proto
 // message.
 //
@@ -177,6 +634,48 @@ func (txn *Txn) GetProto(key interface{}, msg proto.Message) error {
 	return r.ValueProto(msg)
 }
 
+// GetObject retrieves the value for a key and decodes the result, which
+// must have been written by PutObject, into obj.
+//
+// key can be either a byte slice or a string.
+func (txn *Txn) GetObject(key interface{}, obj interface{}) error {
+	r, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	return r.ValueObject(obj)
+}
+
+// GetForUpdate retrieves the value for a key like Get and then writes
+// the value read back, or deletes the key if it doesn't exist, which
+// leaves a write intent on the key for the rest of the transaction.
+// Transactions which conflict with this one on the key after that wait
+// for (or push) the intent like any other.
+//
+// The read doesn't lay down the intent itself: GetForUpdate takes two
+// round trips, and another transaction may write the key in between.
+// That write isn't lost, but it forces this transaction to restart, just
+// as it would have without GetForUpdate. The write back also adds an
+// MVCC version of the key (a deletion if it didn't exist) on commit.
+//
+// key can be either a byte slice or a string.
+func (txn *Txn) GetForUpdate(key interface{}) (KeyValue, error) {
+	kv, err := txn.Get(key)
+	if err != nil {
+		return kv, err
+	}
+	b := txn.NewBatch()
+	if kv.Value == nil {
+		b.Del(kv.Key)
+	} else {
+		b.InternalAddRequest(roachpb.NewPut(kv.Key, roachpb.Value{Bytes: kv.Value.Bytes, Tag: kv.Value.Tag}))
+	}
+	if err := txn.Run(b); err != nil {
+		return KeyValue{}, err
+	}
+	return kv, nil
+}
+
 // Put sets the value for a key
 //
 // key can be either a byte slice or a string. value can be any key type, a
@@ -188,6 +687,17 @@ func (txn *Txn) Put(key, value interface{}) error {
 	return err
 }
 
+// PutObject sets the value for a key to the gob encoding of obj, which
+// can be any value gob can encode, such as a struct.
+//
+// key can be either a byte slice or a string.
+func (txn *Txn) PutObject(key, obj interface{}) error {
+	b := txn.NewBatch()
+	b.PutObject(key, obj)
+	_, err := runOneResult(txn, b)
+	return err
+}
+
 // CPut conditionally sets the value for a key if the existing value is equal
 // to expValue. To conditionally set a value only if there is no existing entry
 // pass nil for expValue.
@@ -246,6 +756,22 @@ func (txn *Txn) ReverseScan(begin, end interface{}, maxRows int64) ([]KeyValue,
 	return txn.scan(begin, end, maxRows, true)
 }
 
+// ScanPage retrieves a page of the rows between begin (inclusive) and
+// end (exclusive) in ascending order. The page contains up to maxRows
+// rows, which must be positive; if maxBytes is positive, the page ends
+// early with the first row which brings the size of its keys and values
+// to maxBytes or more. maxBytes is applied by the client to the rows
+// returned by the server, so it trims the page but does not bound the
+// size of the server's response, which may hold up to maxRows rows.
+//
+// The returned resume key is the key at which to continue the scan to
+// retrieve the next page, and is nil once there are no more rows.
+//
+// key can be either a byte slice or a string.
+func (txn *Txn) ScanPage(begin, end interface{}, maxRows, maxBytes int64) ([]KeyValue, roachpb.Key, error) {
+	return scanPage(txn, txn.NewBatch(), begin, end, maxRows, maxBytes)
+}
+
 // Del deletes one or more keys.
 //
 // key can be either a byte slice or a string.
@@ -269,6 +795,64 @@ func (txn *Txn) DelRange(begin, end interface{}) error {
 	return err
 }
 
+// DelRangeMax deletes at most maxRows rows between begin (inclusive) and
+// end (exclusive), or all of them if maxRows is zero, and returns the
+// number of rows deleted. As with other writes, the deleted span is
+// part of the transaction's intents and is resolved when it ends.
+//
+// key can be either a byte slice or a string.
+func (txn *Txn) DelRangeMax(begin, end interface{}, maxRows int64) (int64, error) {
+	b := txn.NewBatch()
+	b.DelRangeMax(begin, end, maxRows)
+	r, err := runOneResult(txn, b)
+	return r.NumDeleted, err
+}
+
+// queueItemPrefix returns the prefix under which the items of the queue
+// rooted at queue are stored. The queue key itself holds the sequence
+// counter. Items live under a dedicated sub-prefix so that the keys of
+// another queue whose name extends this one's never fall into the span
+// of this queue's items.
+func queueItemPrefix(queue roachpb.Key) roachpb.Key {
+	return append(append(roachpb.Key(nil), queue...), "\x00items\x00"...)
+}
+
+// queueItemKey returns the key under which the item with sequence
+// number seq of the queue rooted at queue is stored. Items sort in
+// order of their sequence numbers.
+func queueItemKey(queue roachpb.Key, seq int64) roachpb.Key {
+	return encoding.EncodeUvarint(queueItemPrefix(queue), uint64(seq))
+}
+
+// Enqueue appends item to the tail of the queue rooted at queue. The
+// queue is stored as the sequence counter at queue and one key per
+// item, so the enqueue is atomic with the rest of the
+// transaction.
+func (txn *Txn) Enqueue(queue roachpb.Key, item []byte) error {
+	kv, err := txn.Inc(queue, 1)
+	if err != nil {
+		return err
+	}
+	return txn.Put(queueItemKey(queue, kv.ValueInt()), item)
+}
+
+// Dequeue removes and returns the item at the head of the queue rooted
+// at queue. The returned bool is false if the queue is empty. The head
+// item is claimed by deleting it within the transaction, so concurrent
+// dequeuers conflict on its write intent and each item is consumed by
+// at most one committed transaction.
+func (txn *Txn) Dequeue(queue roachpb.Key) ([]byte, bool, error) {
+	prefix := queueItemPrefix(queue)
+	rows, err := txn.Scan(prefix, prefix.PrefixEnd(), 1)
+	if err != nil || len(rows) == 0 {
+		return nil, false, err
+	}
+	if err := txn.Del(rows[0].Key); err != nil {
+		return nil, false, err
+	}
+	return rows[0].ValueBytes(), true, nil
+}
+
 // Run executes the operations queued up within a batch. Before executing any
 // of the operations the batch is first checked to see if there were any errors
 // during its construction (e.g. failure to marshal a proto message).
@@ -290,17 +874,265 @@ func (txn *Txn) RunWithResponse(b *Batch) (*roachpb.BatchResponse, error) {
 	if err := b.prepare(); err != nil {
 		return nil, err
 	}
-	return sendAndFill(txn.send, b)
+	for _, args := range b.reqs {
+		if !roachpb.IsTransactional(args) {
+			return nil, &NonTransactionalMethodError{Method: args.Method()}
+		}
+	}
+	if err := txn.checkIntentBudget(b.reqs); err != nil {
+		return nil, err
+	}
+	if b.ReadConsistency == roachpb.INCONSISTENT {
+		// Inconsistent reads aren't allowed within a transaction; send
+		// them without it so they don't affect its timestamp either.
+		db := txn.db
+		db.sender = txn.wrapped
+		return sendAndFill(txn.sendContext(), db.send, b)
+	}
+	return sendAndFill(txn.sendContext(), txn.send, b)
+}
+
+// checkIntentBudget returns a *TransactionTooLargeError if sending reqs
+// would take the transaction past its intent budget.
+func (txn *Txn) checkIntentBudget(reqs []roachpb.Request) error {
+	if txn.maxIntents <= 0 && txn.maxIntentBytes <= 0 {
+		return nil
+	}
+	var newIntents int
+	var newIntentBytes int64
+	for _, args := range reqs {
+		if roachpb.IsTransactionWrite(args) {
+			newIntents++
+			newIntentBytes += writeSize(args)
+		}
+	}
+	if newIntents == 0 {
+		// Reads may be sent concurrently with writes (see ParallelReads),
+		// so they must not look at the writes.
+		return nil
+	}
+	intents, intentBytes := len(txn.writes)+newIntents, txn.writeBytes+newIntentBytes
+	if (txn.maxIntents > 0 && intents > txn.maxIntents) ||
+		(txn.maxIntentBytes > 0 && intentBytes > txn.maxIntentBytes) {
+		return &TransactionTooLargeError{
+			Intents:        intents,
+			IntentBytes:    intentBytes,
+			MaxIntents:     txn.maxIntents,
+			MaxIntentBytes: txn.maxIntentBytes,
+		}
+	}
+	return nil
+}
+
+// RunParallel executes the given batches, which must be independent of
+// each other, and waits for all of them to complete. If the transaction
+// was run with TxnOptions.ParallelReads, read-only batches are sent
+// concurrently with each other and with the remaining batches, which
+// are sent one at a time in the order given. Otherwise all batches are
+// sent one at a time. As with Run, the results of each batch are found
+// in its Results. The error returned is that of the first of the
+// batches to fail in the order given, regardless of the order in which
+// they completed.
+func (txn *Txn) RunParallel(batches ...*Batch) error {
+	errs := make([]error, len(batches))
+	var wg sync.WaitGroup
+	for i, b := range batches {
+		if txn.parallelReads && b.isReadOnly() {
+			wg.Add(1)
+			go func(i int, b *Batch) {
+				defer wg.Done()
+				errs[i] = txn.Run(b)
+			}(i, b)
+		}
+	}
+	for i, b := range batches {
+		if !txn.parallelReads || !b.isReadOnly() {
+			errs[i] = txn.Run(b)
+		}
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (txn *Txn) commit() error {
-	return txn.sendEndTxnReq(true /* commit */)
+	before := txn.Proto
+	if err := txn.checkDeadline(); err != nil {
+		return txn.wrapCommitError(before, err)
+	}
+	_, pErr := txn.send(txn.sendContext(), txn.commitReq())
+	return txn.wrapCommitError(before, pErr.GoError())
 }
 
-// Cleanup cleans up the transaction as appropriate based on err.
-func (txn *Txn) Cleanup(err error) {
+// checkDeadline returns a *TransactionDeadlineExceededError if the
+// transaction is about to commit at a timestamp past its deadline.
+func (txn *Txn) checkDeadline() error {
+	if txn.abortReason == nil && txn.deadline != nil && txn.deadline.Less(txn.Proto.Timestamp) {
+		return &TransactionDeadlineExceededError{
+			Timestamp: txn.Proto.Timestamp,
+			Deadline:  *txn.deadline,
+		}
+	}
+	return nil
+}
+
+// wrapCommitError wraps a non-nil err returned while committing the
+// transaction in a CommitError. before is the transaction as it was
+// prior to the commit attempt, which is reported in case the
+// transaction was reset by an abort.
+func (txn *Txn) wrapCommitError(before roachpb.Transaction, err error) error {
+	if err == nil {
+		return nil
+	}
+	proto := txn.Proto
+	if len(proto.ID) == 0 {
+		proto = before
+	}
+	return &CommitError{
+		Cause:     err,
+		TxnID:     proto.ID,
+		Epoch:     proto.Epoch,
+		Timestamp: proto.Timestamp,
+		Writes:    len(txn.writes),
+		Elapsed:   time.Since(txn.start),
+	}
+}
+
+// A SavepointID identifies a point within a transaction to which its
+// writes can later be rolled back. See Txn.Savepoint.
+type SavepointID struct {
+	txnID  []byte
+	epoch  int32
+	writes int
+}
+
+// Savepoint returns a savepoint which can be passed to
+// RollbackToSavepoint to undo the writes performed after this call.
+// The savepoint is only valid within the current attempt of the
+// transaction; it is invalidated when the transaction restarts.
+func (txn *Txn) Savepoint() (SavepointID, error) {
+	if txn.Proto.Status != roachpb.PENDING {
+		return SavepointID{}, util.Errorf("cannot create savepoint in %s transaction", txn.Proto.Status)
+	}
+	return SavepointID{
+		txnID:  txn.Proto.ID,
+		epoch:  txn.Proto.Epoch,
+		writes: len(txn.writes),
+	}, nil
+}
+
+// RollbackToSavepoint undoes the writes performed since sp was created
+// by removing the intents they laid down, leaving the transaction
+// pending. Writes performed before sp are unaffected. Rolling back is
+// not possible if a key written after sp was also written before it
+// (since the earlier value was overwritten) or if a range was deleted
+// after sp; in those cases an error is returned and nothing is undone.
+func (txn *Txn) RollbackToSavepoint(sp SavepointID) error {
+	if txn.Proto.Status != roachpb.PENDING {
+		return util.Errorf("cannot roll back to savepoint in %s transaction", txn.Proto.Status)
+	}
+	if txn.Proto.Epoch != sp.epoch || len(txn.writes) < sp.writes ||
+		(len(sp.txnID) > 0 && !roachpb.TxnIDEqual(sp.txnID, txn.Proto.ID)) {
+		return util.Errorf("savepoint invalidated by transaction restart")
+	}
+	before := map[string]struct{}{}
+	for _, w := range txn.writes[:sp.writes] {
+		before[string(w.key)] = struct{}{}
+	}
+	for _, w := range txn.writes[sp.writes:] {
+		if w.endKey != nil {
+			return util.Errorf("cannot roll back range write [%s,%s) to savepoint", w.key, w.endKey)
+		}
+		if _, ok := before[string(w.key)]; ok {
+			return util.Errorf("cannot roll back write to %s: key was also written before savepoint", w.key)
+		}
+	}
+	// Resolve the intents as aborted, which removes them without
+	// affecting any committed values. The EndTransaction which eventually
+	// commits the transaction will find no intent on these keys.
+	aborted := txn.Proto.Clone()
+	aborted.Status = roachpb.ABORTED
+	var reqs []roachpb.Request
+	after := map[string]struct{}{}
+	for _, w := range txn.writes[sp.writes:] {
+		if _, ok := after[string(w.key)]; ok {
+			continue
+		}
+		after[string(w.key)] = struct{}{}
+		reqs = append(reqs, &roachpb.ResolveIntentRequest{
+			RequestHeader: roachpb.RequestHeader{Key: w.key},
+			IntentTxn:     *aborted,
+		})
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	if _, pErr := txn.send(txn.sendContext(), reqs...); pErr != nil {
+		return pErr.GoError()
+	}
+	for _, w := range txn.writes[sp.writes:] {
+		txn.writeBytes -= w.size
+	}
+	txn.writes = txn.writes[:sp.writes]
+	return nil
+}
+
+// Nested runs fn as a child of the transaction: the writes performed
+// by fn commit atomically with those of the parent, but if fn returns
+// an error they are rolled back (see RollbackToSavepoint) while the
+// parent's writes are kept, and the error is returned. Retryable errors
+// are returned unchanged since they restart the parent anyway. Nested
+// calls may be nested further.
+func (txn *Txn) Nested(fn func(txn *Txn) error) error {
+	sp, err := txn.Savepoint()
 	if err != nil {
-		if replyErr := txn.Rollback(); replyErr != nil {
+		return err
+	}
+	err = fn(txn)
+	if err == nil {
+		return nil
+	}
+	if restart, _ := isRetryableErr(err); restart {
+		return err
+	}
+	if rbErr := txn.RollbackToSavepoint(sp); rbErr != nil {
+		return util.Errorf("%s (and rolling back nested transaction failed: %s)", err, rbErr)
+	}
+	return err
+}
+
+// PendingIntents returns the key spans written by the current attempt
+// of the transaction, in the order they were written, for inspection
+// before committing. Writes undone by RollbackToSavepoint or by a
+// restart of the transaction are not included. The returned intents
+// carry a copy of the transaction.
+func (txn *Txn) PendingIntents() []roachpb.Intent {
+	txn.protoMu.Lock()
+	proto := txn.Proto.Clone()
+	txn.protoMu.Unlock()
+	intents := make([]roachpb.Intent, 0, len(txn.writes))
+	for _, w := range txn.writes {
+		intents = append(intents, roachpb.Intent{Key: w.key, EndKey: w.endKey, Txn: *proto})
+	}
+	return intents
+}
+
+// Cleanup cleans up the transaction as appropriate based on err. The
+// transaction is aborted with err as the reason. Cleanup stops the
+// heartbeat goroutine, if any, and waits for it to exit. It is
+// idempotent: a transaction which has already been committed or aborted
+// is left alone.
+func (txn *Txn) Cleanup(err error) {
+	txn.stopHeartbeat()
+	txn.protoMu.Lock()
+	status := txn.Proto.Status
+	txn.protoMu.Unlock()
+	if err != nil && status == roachpb.PENDING {
+		if replyErr := txn.AbortWithReason(err.Error()); replyErr != nil {
 			log.Errorf("failure aborting transaction: %s; abort caused by: %s", replyErr, err)
 		}
 	}
@@ -325,9 +1157,14 @@ func (txn *Txn) CommitInBatch(b *Batch) error {
 // CommitInBatchWithResponse is a version of CommitInBatch that returns the
 // BatchResponse.
 func (txn *Txn) CommitInBatchWithResponse(b *Batch) (*roachpb.BatchResponse, error) {
-	b.reqs = append(b.reqs, endTxnReq(true /* commit */, txn.systemDBTrigger))
+	before := txn.Proto
+	if err := txn.checkDeadline(); err != nil {
+		return nil, txn.wrapCommitError(before, err)
+	}
+	b.reqs = append(b.reqs, txn.commitReq())
 	b.initResult(1, 0, nil)
-	return txn.RunWithResponse(b)
+	br, err := txn.RunWithResponse(b)
+	return br, txn.wrapCommitError(before, err)
 }
 
 // Commit sends an EndTransactionRequest with Commit=true.
@@ -337,16 +1174,66 @@ func (txn *Txn) Commit() error {
 	return err
 }
 
+// CommitTimestamp returns the timestamp at which the transaction
+// committed, which reflects any pushes of the transaction's timestamp
+// along the way. It returns the zero timestamp if the transaction has
+// not committed.
+func (txn *Txn) CommitTimestamp() roachpb.Timestamp {
+	txn.protoMu.Lock()
+	defer txn.protoMu.Unlock()
+	if txn.Proto.Status != roachpb.COMMITTED {
+		return roachpb.ZeroTimestamp
+	}
+	return txn.Proto.Timestamp
+}
+
 // Rollback sends an EndTransactionRequest with Commit=false.
 func (txn *Txn) Rollback() error {
-	return txn.sendEndTxnReq(false /* commit */)
+	return txn.AbortWithReason("")
 }
 
-func (txn *Txn) sendEndTxnReq(commit bool) error {
-	_, pErr := txn.send(endTxnReq(commit, txn.systemDBTrigger))
+// AbortIf marks the transaction to be aborted with the given reason
+// instead of committed if cond is true, and is a no-op otherwise.
+// Once marked, committing the transaction, whether explicitly or when
+// the transaction function returns without error, rolls it back. The
+// mark only applies to the current attempt of the transaction: a
+// transaction function which calls AbortIf must do so again when it is
+// retried.
+func (txn *Txn) AbortIf(cond bool, reason string) error {
+	if txn.Proto.Status != roachpb.PENDING {
+		return util.Errorf("cannot mark %s transaction for abort", txn.Proto.Status)
+	}
+	if cond && txn.abortReason == nil {
+		txn.abortReason = &reason
+	}
+	return nil
+}
+
+// AbortWithReason is like Rollback, but records reason with the
+// transaction. The reason is kept by the client only: it is included in
+// the TransactionStatusError returned by any further use of the aborted
+// transaction, but isn't sent with the abort. If the transaction was
+// already marked by AbortIf, that reason is kept instead.
+func (txn *Txn) AbortWithReason(reason string) error {
+	if reason != "" && txn.abortReason == nil {
+		txn.abortReason = &reason
+	}
+	et := endTxnReq(false /* commit */, txn.systemDBTrigger)
+	// Rolling back the transaction must not be cut short by its context,
+	// which may be done already.
+	_, pErr := txn.send(context.TODO(), et)
 	return pErr.GoError()
 }
 
+// commitReq returns the EndTransactionRequest which commits the
+// transaction or, if it was marked by AbortIf, aborts it instead.
+func (txn *Txn) commitReq() roachpb.Request {
+	if txn.abortReason != nil {
+		return endTxnReq(false /* commit */, txn.systemDBTrigger)
+	}
+	return endTxnReq(true /* commit */, txn.systemDBTrigger)
+}
+
 func endTxnReq(commit bool, hasTrigger bool) roachpb.Request {
 	var trigger *roachpb.InternalCommitTrigger
 	if hasTrigger {
@@ -362,45 +1249,244 @@ func endTxnReq(commit bool, hasTrigger bool) roachpb.Request {
 	}
 }
 
-func (txn *Txn) exec(retryable func(txn *Txn) error) error {
+func (txn *Txn) exec(opts TxnOptions, retryable func(txn *Txn) error) error {
+	txn.parallelReads = opts.ParallelReads
+	txn.readOnly = opts.ReadOnly
+	txn.readTimestamp = opts.ReadTimestamp
+	txn.priorityPolicy = opts.PriorityPolicy
+	txn.tracer = opts.Tracer
+	txn.ctx = opts.Context
+	txn.anchorKey = opts.AnchorKey
+	txn.maxIntents, txn.maxIntentBytes = opts.MaxIntents, opts.MaxIntentBytes
+	if len(txn.Proto.ID) == 0 {
+		txn.Proto.Key = opts.AnchorKey
+	}
+	if opts.Deadline != roachpb.ZeroTimestamp {
+		deadline := opts.Deadline
+		txn.deadline = &deadline
+	}
+	if opts.HeartbeatInterval > 0 {
+		txn.startHeartbeat(opts.HeartbeatInterval)
+		defer txn.stopHeartbeat()
+	}
+	// If retryable panics, abort the transaction so that its intents
+	// don't linger until it expires. The panic is re-raised from the
+	// deferred call, on top of the panicking goroutine's stack, so the
+	// stack trace still shows where it originated.
+	defer func() {
+		if r := recover(); r != nil {
+			txn.Cleanup(fmt.Errorf("transaction function panicked: %v", r))
+			panic(r)
+		}
+	}()
 	// Run retryable in a retry loop until we encounter a success or
 	// error condition this loop isn't capable of handling.
+	retryOptions := txn.db.txnRetryOptions
+	if opts.RetryOptions != nil {
+		retryOptions = *opts.RetryOptions
+	}
+	if txn.ctx != nil {
+		retryOptions.Closer = txn.ctx.Done()
+	}
 	var err error
-	for r := retry.Start(txn.db.txnRetryOptions); r.Next(); {
+	for r := retry.Start(retryOptions); r.Next(); {
+		if txn.ctx != nil {
+			if err = txn.ctx.Err(); err != nil {
+				break
+			}
+		}
+		txn.attempt++
+		txn.resetAttempt()
 		err = retryable(txn)
+		if err == nil && txn.swallowedRetry() {
+			// The transaction function ignored a retry error. The commit
+			// will fail with another one since the transaction's timestamp
+			// was pushed, but this indicates a bug in the function.
+			atomic.AddInt64(&txn.db.txnMetrics.SwallowedRetries, 1)
+			log.Warningf("%s: transaction function succeeded despite a retry error; "+
+				"errors returned by the transaction's operations must be returned", txn.DebugName())
+		}
 		if err == nil && txn.Proto.Status == roachpb.PENDING {
 			// retryable succeeded, but didn't commit.
 			err = txn.commit()
 		}
-		if restartErr, ok := err.(roachpb.TransactionRestartError); ok {
-			if log.V(2) {
-				log.Warning(err)
-			}
-			switch restartErr.CanRestartTransaction() {
-			case roachpb.TransactionRestart_IMMEDIATE:
-				r.Reset()
-				continue
-			case roachpb.TransactionRestart_BACKOFF:
-				continue
-			}
-			// By default, fall through and break.
+		restart, immediate := isRetryableErr(err)
+		if !restart || (opts.MaxRestarts > 0 && txn.attempt > opts.MaxRestarts) {
+			break
+		}
+		if log.V(2) {
+			log.Warning(err)
+		}
+		txn.noteRestart(err)
+		if opts.OnRetry != nil {
+			opts.OnRetry(txn.attempt, unwrapCommitError(err))
 		}
-		break
+		if immediate {
+			r.Reset()
+		} else {
+			atomic.AddInt64(&txn.db.txnMetrics.Backoffs, 1)
+		}
+	}
+	if err != nil && txn.ctx != nil && txn.ctx.Err() != nil {
+		// Report the cancellation rather than the error it caused.
+		err = txn.ctx.Err()
 	}
 	txn.Cleanup(err)
 	return err
 }
 
+// swallowedRetry returns whether a retry error was returned to the
+// transaction function during the current attempt.
+func (txn *Txn) swallowedRetry() bool {
+	txn.protoMu.Lock()
+	defer txn.protoMu.Unlock()
+	return txn.retryPending
+}
+
+// resetAttempt clears the state kept for the current attempt of the
+// transaction before the next one starts.
+func (txn *Txn) resetAttempt() {
+	txn.writes = nil
+	txn.writeBytes = 0
+	txn.abortReason = nil
+	txn.protoMu.Lock()
+	txn.retryPending = false
+	txn.protoMu.Unlock()
+}
+
+// noteRestart records that the transaction is restarted because of err.
+func (txn *Txn) noteRestart(err error) {
+	atomic.AddInt64(&txn.db.txnMetrics.Restarts, 1)
+	txn.fromScratch = false
+	switch unwrapCommitError(err).(type) {
+	case *roachpb.TransactionRetryError:
+		atomic.AddInt64(&txn.db.txnMetrics.RetryRestarts, 1)
+	case *roachpb.TransactionAbortedError:
+		atomic.AddInt64(&txn.db.txnMetrics.AbortRestarts, 1)
+		txn.fromScratch = true
+	case *roachpb.TransactionPushError:
+		atomic.AddInt64(&txn.db.txnMetrics.PushRestarts, 1)
+	}
+}
+
+// PrepareForRetry readies a transaction which isn't run through DB.Txn
+// (see DB.NewTxn) to be retried after one of its operations failed with
+// err, an error for which IsRetryableErr returns true. The caller then
+// issues all of the transaction's operations again. Savepoints and
+// AbortIf marks of the failed attempt are discarded. Commit and Rollback
+// finalize the transaction even when they fail, so a transaction whose
+// commit failed must be retried with a new Txn instead.
+func (txn *Txn) PrepareForRetry(err error) {
+	txn.noteRestart(err)
+	txn.resetAttempt()
+}
+
+// IsRetryableErr returns whether a transaction which failed with err
+// can be retried. DB.Txn does so automatically; transactions driven
+// manually use PrepareForRetry.
+func IsRetryableErr(err error) bool {
+	restart, _ := isRetryableErr(err)
+	return restart
+}
+
+// isRetryableErr returns whether a transaction which failed with err
+// should be retried and, if so, whether the retry should happen
+// immediately rather than after backing off.
+func isRetryableErr(err error) (restart, immediate bool) {
+	restartErr, ok := unwrapCommitError(err).(roachpb.TransactionRestartError)
+	if !ok {
+		return false, false
+	}
+	switch restartErr.CanRestartTransaction() {
+	case roachpb.TransactionRestart_IMMEDIATE:
+		return true, true
+	case roachpb.TransactionRestart_BACKOFF:
+		return true, false
+	}
+	return false, false
+}
+
+// startHeartbeat starts a goroutine which heartbeats the transaction at
+// the given interval until stopHeartbeat is called.
+func (txn *Txn) startHeartbeat(interval time.Duration) {
+	txn.heartbeatStop = make(chan struct{})
+	txn.heartbeatDone = make(chan struct{})
+	go txn.heartbeatLoop(interval, txn.heartbeatStop, txn.heartbeatDone)
+}
+
+// stopHeartbeat stops the heartbeat goroutine, if any, and waits for it
+// to exit. It is idempotent.
+func (txn *Txn) stopHeartbeat() {
+	if txn.heartbeatStop == nil {
+		return
+	}
+	close(txn.heartbeatStop)
+	<-txn.heartbeatDone
+	txn.heartbeatStop, txn.heartbeatDone = nil, nil
+}
+
+func (txn *Txn) heartbeatLoop(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			txn.heartbeat()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// heartbeat sends a single HeartbeatTxn request for the current
+// incarnation of the transaction. The transaction may be restarted or
+// recreated concurrently, so it is copied under protoMu.
+func (txn *Txn) heartbeat() {
+	txn.protoMu.Lock()
+	if !txn.Proto.Writing || txn.Proto.Status != roachpb.PENDING {
+		txn.protoMu.Unlock()
+		return
+	}
+	proto := txn.Proto.Clone()
+	txn.protoMu.Unlock()
+
+	hb := &roachpb.HeartbeatTxnRequest{}
+	hb.Key = proto.Key
+	ba := roachpb.BatchRequest{}
+	ba.Txn = proto
+	ba.Add(hb)
+	if _, pErr := txn.wrapped.Send(context.TODO(), ba); pErr != nil && log.V(1) {
+		log.Infof("heartbeat to %s failed: %s", proto, pErr)
+	}
+}
+
+// sendContext returns the context with which the transaction's
+// requests are sent: the one set via TxnOptions.Context, if any.
+func (txn *Txn) sendContext() context.Context {
+	if txn.ctx != nil {
+		return txn.ctx
+	}
+	return context.TODO()
+}
+
 // send runs the specified calls synchronously in a single batch and
 // returns any errors. If the transaction is read-only or has already
 // been successfully committed or aborted, a potential trailing
 // EndTransaction call is silently dropped, allowing the caller to
 // always commit or clean-up explicitly even when that may not be
 // required (or even erroneous).
-func (txn *Txn) send(reqs ...roachpb.Request) (*roachpb.BatchResponse, *roachpb.Error) {
+func (txn *Txn) send(ctx context.Context, reqs ...roachpb.Request) (*roachpb.BatchResponse, *roachpb.Error) {
+	txn.protoMu.Lock()
+	status, writing := txn.Proto.Status, txn.Proto.Writing
+	var pErr *roachpb.Error
+	if status != roachpb.PENDING {
+		pErr = newTxnFinalizedError(txn.Proto, txn.abortReason)
+	}
+	txn.protoMu.Unlock()
 
-	if txn.Proto.Status != roachpb.PENDING {
-		return nil, roachpb.NewError(util.Errorf("attempting to use %s transaction", txn.Proto.Status))
+	if pErr != nil {
+		return nil, pErr
 	}
 
 	lastIndex := len(reqs) - 1
@@ -415,6 +1501,18 @@ func (txn *Txn) send(reqs ...roachpb.Request) (*roachpb.BatchResponse, *roachpb.
 	// transaction.
 	haveTxnWrite := roachpb.IsTransactionWrite(lastReq)
 
+	for _, args := range reqs {
+		if roachpb.IsTransactionWrite(args) {
+			if txn.readTimestamp != roachpb.ZeroTimestamp {
+				return nil, roachpb.NewError(util.Errorf("%s not permitted in transaction reading at fixed timestamp %s",
+					args.Method(), txn.readTimestamp))
+			}
+			if txn.readOnly {
+				return nil, roachpb.NewError(util.Errorf("%s not permitted in read-only transaction", args.Method()))
+			}
+		}
+	}
+
 	for _, args := range reqs[:lastIndex] {
 		if _, ok := args.(*roachpb.EndTransactionRequest); ok {
 			return nil, roachpb.NewError(util.Errorf("%s sent as non-terminal call", args.Method()))
@@ -426,24 +1524,55 @@ func (txn *Txn) send(reqs ...roachpb.Request) (*roachpb.BatchResponse, *roachpb.
 	}
 
 	endTxnRequest, haveEndTxn := lastReq.(*roachpb.EndTransactionRequest)
-	needEndTxn := txn.Proto.Writing || haveTxnWrite
+	// Don't send anything once the context is done. Rollbacks are sent
+	// with a context of their own (see AbortWithReason).
+	if err := ctx.Err(); err != nil {
+		return nil, roachpb.NewError(err)
+	}
+	needEndTxn := writing || haveTxnWrite
 	elideEndTxn := haveEndTxn && !needEndTxn
 
 	if elideEndTxn {
 		reqs = reqs[:lastIndex]
 	}
+	if haveEndTxn {
+		// Don't heartbeat a transaction which is about to be finalized.
+		txn.stopHeartbeat()
+	}
 
-	br, pErr := txn.db.send(reqs...)
+	for _, args := range reqs {
+		if roachpb.IsTransactionWrite(args) {
+			h := args.Header()
+			size := writeSize(args)
+			txn.writes = append(txn.writes, writeSpan{key: h.Key, endKey: h.EndKey, size: size})
+			txn.writeBytes += size
+		}
+	}
+	atomic.AddInt64(&txn.db.txnMetrics.Requests, 1)
+	br, pErr := txn.db.send(ctx, reqs...)
+	if haveEndTxn && pErr == nil {
+		if endTxnRequest.Commit {
+			atomic.AddInt64(&txn.db.txnMetrics.Committed, 1)
+			atomic.AddInt64(&txn.db.txnMetrics.CommitNanos, time.Since(txn.start).Nanoseconds())
+			if !writing && haveTxnWrite {
+				atomic.AddInt64(&txn.db.txnMetrics.SingleBatchCommits, 1)
+			}
+		} else {
+			atomic.AddInt64(&txn.db.txnMetrics.Aborted, 1)
+		}
+	}
 	if elideEndTxn && pErr == nil {
 		// This normally happens on the server and sent back in response
 		// headers, but this transaction was optimized away. The caller may
 		// still inspect the transaction struct, so we manually update it
 		// here to emulate a true transaction.
+		txn.protoMu.Lock()
 		if endTxnRequest.Commit {
 			txn.Proto.Status = roachpb.COMMITTED
 		} else {
 			txn.Proto.Status = roachpb.ABORTED
 		}
+		txn.protoMu.Unlock()
 	}
 	return br, pErr
 }
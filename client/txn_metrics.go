@@ -0,0 +1,80 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package client
+
+import "sync/atomic"
+
+// TxnMetrics holds the transaction counters of a DB at the time they
+// were retrieved.
+type TxnMetrics struct {
+	// Started is the number of transactions created.
+	Started int64
+	// Committed and Aborted are the number of transactions which were
+	// successfully committed or rolled back, respectively.
+	Committed, Aborted int64
+	// Restarts is the number of times a transaction was retried.
+	Restarts int64
+	// RetryRestarts, AbortRestarts and PushRestarts are the number of
+	// restarts caused by a TransactionRetryError (the transaction's
+	// timestamp was pushed), by a TransactionAbortedError and by a
+	// TransactionPushError (the transaction failed to push the writer of
+	// a conflicting intent), respectively. Restarts for other causes,
+	// such as uncertainty, are only counted in Restarts.
+	RetryRestarts, AbortRestarts, PushRestarts int64
+	// Backoffs is the number of retries which backed off before
+	// restarting, which happens when the transaction lost a conflict
+	// with another transaction.
+	Backoffs int64
+	// SwallowedRetries is the number of attempts of SERIALIZABLE
+	// transactions whose transaction function returned successfully
+	// despite one of its operations failing with a retry error. Such a
+	// function is buggy: it would run at snapshot isolation if the
+	// commit didn't fail as well.
+	SwallowedRetries int64
+	// Requests is the number of batches sent by transactions.
+	Requests int64
+	// SingleBatchCommits is the number of committed transactions whose
+	// writes were all sent in the same batch as the commit. Such a
+	// transaction commits in a single round trip if its writes all go to
+	// the range holding its record. Otherwise, the commit is sent after
+	// the writes, so this is an upper bound on one-phase commits.
+	SingleBatchCommits int64
+	// CommitNanos is the sum of the durations of all committed
+	// transactions, from their creation to their commit. Divided by
+	// Committed, it gives the mean commit latency.
+	CommitNanos int64
+}
+
+// TxnMetrics returns the counters of the transactions run on the DB.
+// The counters are read individually, so they may not be consistent
+// with each other when transactions are running concurrently.
+func (db *DB) TxnMetrics() TxnMetrics {
+	m := db.txnMetrics
+	return TxnMetrics{
+		Started:            atomic.LoadInt64(&m.Started),
+		Committed:          atomic.LoadInt64(&m.Committed),
+		Aborted:            atomic.LoadInt64(&m.Aborted),
+		Restarts:           atomic.LoadInt64(&m.Restarts),
+		Backoffs:           atomic.LoadInt64(&m.Backoffs),
+		RetryRestarts:      atomic.LoadInt64(&m.RetryRestarts),
+		AbortRestarts:      atomic.LoadInt64(&m.AbortRestarts),
+		PushRestarts:       atomic.LoadInt64(&m.PushRestarts),
+		SwallowedRetries:   atomic.LoadInt64(&m.SwallowedRetries),
+		Requests:           atomic.LoadInt64(&m.Requests),
+		SingleBatchCommits: atomic.LoadInt64(&m.SingleBatchCommits),
+		CommitNanos:        atomic.LoadInt64(&m.CommitNanos),
+	}
+}
@@ -18,16 +18,24 @@
 package client
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"golang.org/x/net/context"
 
 	"github.com/cockroachdb/cockroach/roachpb"
+	"github.com/cockroachdb/cockroach/testutils"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/leaktest"
+	"github.com/cockroachdb/cockroach/util/retry"
+	"github.com/cockroachdb/cockroach/util/stop"
+	"github.com/cockroachdb/cockroach/util/tracer"
 	"github.com/cockroachdb/cockroach/util/uuid"
 	"github.com/gogo/protobuf/proto"
 )
@@ -42,6 +50,7 @@ func newDB(sender Sender) *DB {
 	return &DB{
 		sender:          sender,
 		txnRetryOptions: DefaultTxnRetryOptions,
+		txnMetrics:      &TxnMetrics{},
 	}
 }
 
@@ -236,6 +245,38 @@ func TestCommitReadOnlyTransactionExplicit(t *testing.T) {
 	}
 }
 
+// TestCommitReadOnlyScanTransaction verifies that a transaction
+// consisting solely of scans does not send an EndTransaction call,
+// whether the commit is implicit or explicit.
+func TestCommitReadOnlyScanTransaction(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	for _, explicit := range []bool{true, false} {
+		var calls []roachpb.Method
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			calls = append(calls, ba.Methods()...)
+			return ba.CreateReply(), nil
+		}, nil))
+		if err := db.Txn(func(txn *Txn) error {
+			if _, err := txn.Scan("a", "b", 0); err != nil {
+				return err
+			}
+			if _, err := txn.ReverseScan("c", "d", 0); err != nil {
+				return err
+			}
+			if explicit {
+				return txn.CommitInBatch(&Batch{})
+			}
+			return nil
+		}); err != nil {
+			t.Errorf("unexpected error on commit: %s", err)
+		}
+		expectedCalls := []roachpb.Method{roachpb.Scan, roachpb.ReverseScan}
+		if !reflect.DeepEqual(expectedCalls, calls) {
+			t.Errorf("explicit=%t: expected %s, got %s", explicit, expectedCalls, calls)
+		}
+	}
+}
+
 // TestCommitMutatingTransaction verifies that transaction is committed
 // upon successful invocation of the retryable func.
 func TestCommitMutatingTransaction(t *testing.T) {
@@ -335,7 +376,9 @@ func TestEndWriteRestartReadOnlyTransaction(t *testing.T) {
 }
 
 // TestAbortMutatingTransaction verifies that transaction is aborted
-// upon failed invocation of the retryable func.
+// upon failed invocation of the retryable func, that it isn't retried,
+// that the error is returned unchanged, and that the error is reported
+// as the abort reason when the transaction is used afterwards.
 func TestAbortMutatingTransaction(t *testing.T) {
 	defer leaktest.AfterTest(t)
 	var calls []roachpb.Method
@@ -347,22 +390,34 @@ func TestAbortMutatingTransaction(t *testing.T) {
 		return ba.CreateReply(), nil
 	}, nil))
 
+	var attempts int
+	var aborted *Txn
+	fooErr := errors.New("foo")
 	if err := db.Txn(func(txn *Txn) error {
+		attempts++
+		aborted = txn
 		if err := txn.Put("a", "b"); err != nil {
 			return err
 		}
-		return errors.New("foo")
-	}); err == nil {
-		t.Error("expected error on abort")
+		return fooErr
+	}); err != fooErr {
+		t.Errorf("expected %s on abort; got %v", fooErr, err)
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt; got %d", attempts)
 	}
 	expectedCalls := []roachpb.Method{roachpb.Put, roachpb.EndTransaction}
 	if !reflect.DeepEqual(expectedCalls, calls) {
 		t.Errorf("expected %s, got %s", expectedCalls, calls)
 	}
+	if _, err := aborted.Get("a"); !testutils.IsError(err, "aborted: foo") {
+		t.Errorf("expected the abort reason to be reported; got %v", err)
+	}
 }
 
 // TestRunTransactionRetryOnErrors verifies that the transaction
-// is retried on the correct errors.
+// is retried on the correct errors, whether they are returned for a
+// single command or for any command of a batch.
 func TestRunTransactionRetryOnErrors(t *testing.T) {
 	defer leaktest.AfterTest(t)
 	testCases := []struct {
@@ -379,34 +434,43 @@ func TestRunTransactionRetryOnErrors(t *testing.T) {
 	}
 
 	for i, test := range testCases {
-		count := 0
-		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		for _, batch := range []bool{false, true} {
+			count := 0
+			db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
 
-			if _, ok := ba.GetArg(roachpb.Put); ok {
-				count++
-				if count == 1 {
-					return nil, roachpb.NewError(test.err)
+				if _, ok := ba.GetArg(roachpb.Put); ok {
+					count++
+					if count == 1 {
+						return nil, roachpb.NewError(test.err)
+					}
+				}
+				return ba.CreateReply(), nil
+			}, nil))
+			db.txnRetryOptions.InitialBackoff = 1 * time.Millisecond
+			err := db.Txn(func(txn *Txn) error {
+				if !batch {
+					return txn.Put("a", "b")
+				}
+				b := txn.NewBatch()
+				b.Get("a")
+				b.Put("b", "c")
+				b.Get("d")
+				return txn.Run(b)
+			})
+			if test.retry {
+				if count != 2 {
+					t.Errorf("%d (batch=%t): expected one retry; got %d", i, batch, count-1)
+				}
+				if err != nil {
+					t.Errorf("%d (batch=%t): expected success on retry; got %s", i, batch, err)
+				}
+			} else {
+				if count != 1 {
+					t.Errorf("%d (batch=%t): expected no retries; got %d", i, batch, count)
+				}
+				if reflect.TypeOf(err) != reflect.TypeOf(test.err) {
+					t.Errorf("%d (batch=%t): expected error of type %T; got %T", i, batch, test.err, err)
 				}
-			}
-			return ba.CreateReply(), nil
-		}, nil))
-		db.txnRetryOptions.InitialBackoff = 1 * time.Millisecond
-		err := db.Txn(func(txn *Txn) error {
-			return txn.Put("a", "b")
-		})
-		if test.retry {
-			if count != 2 {
-				t.Errorf("%d: expected one retry; got %d", i, count-1)
-			}
-			if err != nil {
-				t.Errorf("%d: expected success on retry; got %s", i, err)
-			}
-		} else {
-			if count != 1 {
-				t.Errorf("%d: expected no retries; got %d", i, count)
-			}
-			if reflect.TypeOf(err) != reflect.TypeOf(test.err) {
-				t.Errorf("%d: expected error of type %T; got %T", i, test.err, err)
 			}
 		}
 	}
@@ -460,6 +524,97 @@ func TestAbortTransactionOnCommitErrors(t *testing.T) {
 	}
 }
 
+// TestTxnManualRetry verifies that a transaction driven manually can
+// be retried after a retryable error and then committed.
+func TestTxnManualRetry(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var puts int
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if _, ok := ba.GetArg(roachpb.Put); ok {
+			puts++
+			if puts == 2 {
+				return nil, roachpb.NewError(&roachpb.TransactionRetryError{})
+			}
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+	txn := db.NewTxn()
+	for {
+		err := txn.Put("a", "b")
+		if err == nil {
+			err = txn.Put("c", "d")
+		}
+		if err == nil {
+			break
+		}
+		if !IsRetryableErr(err) {
+			t.Fatal(err)
+		}
+		txn.PrepareForRetry(err)
+	}
+	if len(txn.PendingIntents()) != 2 {
+		t.Errorf("expected writes of the failed attempt to be forgotten; got %+v", txn.PendingIntents())
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if puts != 4 {
+		t.Errorf("expected 4 puts; got %d", puts)
+	}
+	if txn.Proto.Status != roachpb.COMMITTED {
+		t.Errorf("expected transaction to be committed; got %s", txn.Proto.Status)
+	}
+}
+
+// TestTxnGetForUpdate verifies that GetForUpdate returns the value read
+// and writes it back, or deletes the key if it doesn't exist.
+func TestTxnGetForUpdate(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var writes []roachpb.Request
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		br := ba.CreateReply()
+		for i, union := range ba.Requests {
+			switch args := union.GetInner().(type) {
+			case *roachpb.GetRequest:
+				if args.Key.Equal(roachpb.Key("a")) {
+					br.Responses[i].GetInner().(*roachpb.GetResponse).Value = &roachpb.Value{Bytes: []byte("1")}
+				}
+			case *roachpb.PutRequest, *roachpb.DeleteRequest:
+				writes = append(writes, args)
+			}
+		}
+		return br, nil
+	}, nil))
+	if err := db.Txn(func(txn *Txn) error {
+		kv, err := txn.GetForUpdate("a")
+		if err != nil {
+			return err
+		}
+		if string(kv.ValueBytes()) != "1" {
+			t.Errorf("expected value 1; got %q", kv.ValueBytes())
+		}
+		kv, err = txn.GetForUpdate("b")
+		if err != nil {
+			return err
+		}
+		if kv.Exists() {
+			t.Errorf("expected b not to exist; got %q", kv.ValueBytes())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 2 {
+		t.Fatalf("expected 2 writes; got %+v", writes)
+	}
+	if put, ok := writes[0].(*roachpb.PutRequest); !ok || !put.Key.Equal(roachpb.Key("a")) || string(put.Value.Bytes) != "1" {
+		t.Errorf("expected value of a to be written back; got %+v", writes[0])
+	}
+	if del, ok := writes[1].(*roachpb.DeleteRequest); !ok || !del.Key.Equal(roachpb.Key("b")) {
+		t.Errorf("expected b to be deleted; got %+v", writes[1])
+	}
+}
+
 // TestTransactionStatus verifies that transactions always have their
 // status updated correctly.
 func TestTransactionStatus(t *testing.T) {
@@ -496,3 +651,1571 @@ func TestTransactionStatus(t *testing.T) {
 		}
 	}
 }
+
+// TestTxnHeartbeat verifies that a transaction started with a heartbeat
+// interval sends HeartbeatTxn requests while retryable runs, that
+// heartbeats stop before the transaction is committed, and that
+// transactions are not heartbeat from the client unless requested.
+func TestTxnHeartbeat(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	for _, interval := range []time.Duration{0, time.Millisecond} {
+		var mu sync.Mutex
+		var calls []roachpb.Method
+		heartbeats := 0
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, ba.Methods()...)
+			if _, ok := ba.GetArg(roachpb.HeartbeatTxn); ok {
+				heartbeats++
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		getHeartbeats := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return heartbeats
+		}
+		opts := TxnOptions{HeartbeatInterval: interval}
+		if err := db.TxnWithOptions(opts, func(txn *Txn) error {
+			if err := txn.Put("a", "b"); err != nil {
+				return err
+			}
+			// Simulate lengthy work between requests.
+			if interval == 0 {
+				time.Sleep(5 * time.Millisecond)
+				return nil
+			}
+			return util.IsTrueWithin(func() bool { return getHeartbeats() >= 2 }, 500*time.Millisecond)
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		if interval == 0 {
+			expectedCalls := []roachpb.Method{roachpb.Put, roachpb.EndTransaction}
+			if !reflect.DeepEqual(expectedCalls, calls) {
+				t.Errorf("expected %s, got %s", expectedCalls, calls)
+			}
+		} else if calls[0] != roachpb.Put || calls[len(calls)-1] != roachpb.EndTransaction {
+			t.Errorf("expected Put first and EndTransaction last; got %s", calls)
+		} else {
+			for _, m := range calls[1 : len(calls)-1] {
+				if m != roachpb.HeartbeatTxn {
+					t.Errorf("unexpected call %s between Put and EndTransaction: %s", m, calls)
+				}
+			}
+		}
+		mu.Unlock()
+	}
+}
+
+// TestCommitErrorDiagnostics verifies that an error returned when
+// committing a transaction is wrapped with diagnostic information
+// while the original error remains available.
+func TestCommitErrorDiagnostics(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if _, ok := ba.GetArg(roachpb.EndTransaction); ok {
+			return nil, roachpb.NewError(&roachpb.TransactionStatusError{})
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+	err := db.Txn(func(txn *Txn) error {
+		if err := txn.Put("a", "b"); err != nil {
+			return err
+		}
+		return txn.Put("c", "d")
+	})
+	cErr, ok := err.(*CommitError)
+	if !ok {
+		t.Fatalf("expected CommitError; got %T: %v", err, err)
+	}
+	if _, ok := cErr.Cause.(*roachpb.TransactionStatusError); !ok {
+		t.Errorf("expected TransactionStatusError cause; got %T", cErr.Cause)
+	}
+	if len(cErr.TxnID) == 0 {
+		t.Errorf("expected txn ID to be set")
+	}
+	if cErr.Writes != 2 {
+		t.Errorf("expected 2 writes; got %d", cErr.Writes)
+	}
+	if cErr.Elapsed <= 0 {
+		t.Errorf("expected positive elapsed time; got %s", cErr.Elapsed)
+	}
+	for _, s := range []string{"epoch=", "ts=", "writes=2", "elapsed="} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %q in error %q", s, err)
+		}
+	}
+}
+
+// TestRollbackToSavepoint verifies that rolling back to a savepoint
+// removes the intents written after it, and only those.
+func TestRollbackToSavepoint(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var resolved []string
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		for _, union := range ba.Requests {
+			if ri, ok := union.GetInner().(*roachpb.ResolveIntentRequest); ok {
+				if ri.IntentTxn.Status != roachpb.ABORTED {
+					t.Errorf("expected intent to be resolved as aborted; got %s", ri.IntentTxn.Status)
+				}
+				resolved = append(resolved, string(ri.Key))
+			}
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+	if err := db.Txn(func(txn *Txn) error {
+		if err := txn.Put("a", "1"); err != nil {
+			return err
+		}
+		sp, err := txn.Savepoint()
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"b", "c", "b"} {
+			if err := txn.Put(k, "2"); err != nil {
+				return err
+			}
+		}
+		if err := txn.RollbackToSavepoint(sp); err != nil {
+			return err
+		}
+		// Rolling back again is a no-op.
+		return txn.RollbackToSavepoint(sp)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"b", "c"}; !reflect.DeepEqual(expected, resolved) {
+		t.Errorf("expected resolved keys %s; got %s", expected, resolved)
+	}
+}
+
+// TestRollbackToSavepointErrors verifies that rolling back to a
+// savepoint fails when the writes since cannot be undone or when the
+// savepoint was invalidated by a restart.
+func TestRollbackToSavepointErrors(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	testCases := []struct {
+		fn     func(txn *Txn, sp SavepointID) error
+		expErr string
+	}{
+		{func(txn *Txn, sp SavepointID) error {
+			return txn.Put("a", "2")
+		}, "also written before savepoint"},
+		{func(txn *Txn, sp SavepointID) error {
+			return txn.DelRange("b", "c")
+		}, "cannot roll back range write"},
+		{func(txn *Txn, sp SavepointID) error {
+			txn.Proto.Restart(0, 0, txn.Proto.Timestamp)
+			return nil
+		}, "invalidated by transaction restart"},
+	}
+	for i, test := range testCases {
+		db := newDB(newTestSender(nil, nil))
+		txn := NewTxn(*db)
+		if err := txn.Put("a", "1"); err != nil {
+			t.Fatal(err)
+		}
+		sp, err := txn.Savepoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := test.fn(txn, sp); err != nil {
+			t.Fatal(err)
+		}
+		if err := txn.RollbackToSavepoint(sp); !testutils.IsError(err, test.expErr) {
+			t.Errorf("%d: expected error %q; got %v", i, test.expErr, err)
+		}
+	}
+}
+
+// TestTxnNested verifies that a failed nested transaction rolls back
+// only its own writes and leaves the parent able to commit.
+func TestTxnNested(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var resolved []string
+	var committed bool
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		for _, union := range ba.Requests {
+			switch args := union.GetInner().(type) {
+			case *roachpb.ResolveIntentRequest:
+				resolved = append(resolved, string(args.Key))
+			case *roachpb.EndTransactionRequest:
+				committed = args.Commit
+			}
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+	if err := db.Txn(func(txn *Txn) error {
+		if err := txn.Put("a", "1"); err != nil {
+			return err
+		}
+		if err := txn.Nested(func(txn *Txn) error {
+			return txn.Put("b", "1")
+		}); err != nil {
+			return err
+		}
+		err := txn.Nested(func(txn *Txn) error {
+			if err := txn.Put("c", "1"); err != nil {
+				return err
+			}
+			if err := txn.Nested(func(txn *Txn) error {
+				return txn.Put("d", "1")
+			}); err != nil {
+				return err
+			}
+			return util.Errorf("boom")
+		})
+		if !testutils.IsError(err, "boom") {
+			t.Errorf("expected nested error; got %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"c", "d"}; !reflect.DeepEqual(expected, resolved) {
+		t.Errorf("expected resolved keys %s; got %s", expected, resolved)
+	}
+	if !committed {
+		t.Error("expected parent transaction to commit")
+	}
+}
+
+// TestTxnParallelReads verifies that with ParallelReads set, reads
+// within a transaction are sent concurrently and the timestamps they
+// return are merged into the transaction.
+func TestTxnParallelReads(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	const numReads = 3
+	var wg sync.WaitGroup
+	wg.Add(numReads)
+	var mu sync.Mutex
+	var wallTime int64
+	db := newDB(newTestSender(nil, func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		br := ba.CreateReply()
+		br.Txn = ba.Txn.Clone()
+		if _, ok := ba.GetArg(roachpb.Get); ok {
+			// Wait for all reads to be in flight at once.
+			wg.Done()
+			wg.Wait()
+			mu.Lock()
+			wallTime++
+			br.Txn.Timestamp.WallTime = wallTime * 10
+			mu.Unlock()
+		}
+		return br, nil
+	}))
+	opts := TxnOptions{ParallelReads: true}
+	if err := db.TxnWithOptions(opts, func(txn *Txn) error {
+		if err := txn.Put("a", "b"); err != nil {
+			return err
+		}
+		errs := make(chan error, numReads)
+		for i := 0; i < numReads; i++ {
+			go func() {
+				_, err := txn.Get("a")
+				errs <- err
+			}()
+		}
+		for i := 0; i < numReads; i++ {
+			if err := <-errs; err != nil {
+				return err
+			}
+		}
+		if ts := txn.Proto.Timestamp.WallTime; ts != numReads*10 {
+			t.Errorf("expected merged timestamp %d; got %d", numReads*10, ts)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTxnRunParallel verifies that RunParallel sends read-only batches
+// concurrently and returns the error of the first failing batch in the
+// order given.
+func TestTxnRunParallel(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	const numReads = 3
+	var wg sync.WaitGroup
+	wg.Add(numReads)
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if args, ok := ba.GetArg(roachpb.Get); ok {
+			// Wait for all reads to be in flight at once.
+			wg.Done()
+			wg.Wait()
+			if key := args.Header().Key; !key.Equal(roachpb.Key("a")) {
+				return nil, roachpb.NewError(util.Errorf("boom %s", key))
+			}
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+	opts := TxnOptions{ParallelReads: true}
+	if err := db.TxnWithOptions(opts, func(txn *Txn) error {
+		if err := txn.Put("x", "y"); err != nil {
+			return err
+		}
+		var batches []*Batch
+		for _, k := range []string{"a", "b", "c"} {
+			b := txn.NewBatch()
+			b.Get(k)
+			batches = append(batches, b)
+		}
+		b := txn.NewBatch()
+		b.Put("z", "y")
+		batches = append(batches, b)
+		if err := txn.RunParallel(batches...); !testutils.IsError(err, `boom "b"`) {
+			t.Errorf("expected error of the first failing batch; got %v", err)
+		}
+		if err := batches[2].Results[0].Err; !testutils.IsError(err, `boom "c"`) {
+			t.Errorf("expected error in the results of the last read; got %v", err)
+		}
+		if err := batches[3].Results[0].Err; err != nil {
+			t.Errorf("expected write to succeed; got %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTxnMisuseErrors verifies that sending a non-transactional method
+// through a transaction returns a NonTransactionalMethodError, which
+// leaves the transaction usable and, if returned, aborts it without a
+// retry. Once the transaction is finalized, using it keeps returning a
+// TransactionStatusError, i.e. neither error path leaves protoMu or
+// sendMu held.
+func TestTxnMisuseErrors(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	testCases := []struct {
+		parallelReads bool
+		returnErr     bool
+		expStatus     roachpb.TransactionStatus
+	}{
+		{false, true, roachpb.ABORTED},
+		{true, false, roachpb.COMMITTED},
+	}
+	for i, test := range testCases {
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if _, ok := ba.GetArg(roachpb.AdminSplit); ok {
+				t.Errorf("%d: unexpected admin split", i)
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		var count int
+		var finished *Txn
+		err := db.TxnWithOptions(TxnOptions{ParallelReads: test.parallelReads}, func(txn *Txn) error {
+			count++
+			finished = txn
+			b := txn.NewBatch()
+			b.InternalAddRequest(&roachpb.AdminSplitRequest{SplitKey: roachpb.Key("a")})
+			runErr := txn.Run(b)
+			if nErr, ok := runErr.(*NonTransactionalMethodError); !ok {
+				t.Errorf("%d: expected NonTransactionalMethodError; got %v", i, runErr)
+			} else if nErr.Method != roachpb.AdminSplit {
+				t.Errorf("%d: expected method %s; got %s", i, roachpb.AdminSplit, nErr.Method)
+			}
+			if err := txn.Put("a", "b"); err != nil {
+				return err
+			}
+			if _, err := txn.Get("a"); err != nil {
+				return err
+			}
+			if test.returnErr {
+				return runErr
+			}
+			return nil
+		})
+		if test.returnErr != (err != nil) {
+			t.Errorf("%d: unexpected error %v", i, err)
+		}
+		if count != 1 {
+			t.Errorf("%d: expected 1 attempt; got %d", i, count)
+		}
+		for j := 0; j < 2; j++ {
+			_, err := finished.Get("a")
+			if sErr, ok := err.(*roachpb.TransactionStatusError); !ok {
+				t.Fatalf("%d: expected TransactionStatusError; got %v", i, err)
+			} else if sErr.Txn.Status != test.expStatus {
+				t.Errorf("%d: expected status %s; got %s", i, test.expStatus, sErr.Txn.Status)
+			}
+		}
+	}
+}
+
+// TestTxnDeadline verifies that a transaction pushed past its
+// deadline, whether set via TxnOptions or tightened via SetDeadline,
+// fails to commit without being retried and is aborted, while one
+// within its deadline commits.
+func TestTxnDeadline(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	testCases := []struct {
+		opts   TxnOptions
+		set    []roachpb.Timestamp
+		pushTo int64
+		expErr bool
+	}{
+		{TxnOptions{Deadline: roachpb.Timestamp{WallTime: 10}}, nil, 10, false},
+		{TxnOptions{Deadline: roachpb.Timestamp{WallTime: 10}}, nil, 20, true},
+		// A later deadline doesn't loosen an earlier one.
+		{TxnOptions{}, []roachpb.Timestamp{{WallTime: 20}, {WallTime: 30}}, 25, true},
+		{TxnOptions{}, []roachpb.Timestamp{{WallTime: 30}, {WallTime: 20}}, 20, false},
+	}
+	for i, test := range testCases {
+		var attempts int
+		var ets []roachpb.EndTransactionRequest
+		db := newDB(newTestSender(nil, func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			br := ba.CreateReply()
+			br.Txn = proto.Clone(ba.Txn).(*roachpb.Transaction)
+			br.Txn.Writing = true
+			if _, ok := ba.GetArg(roachpb.Put); ok {
+				br.Txn.Timestamp.Forward(roachpb.Timestamp{WallTime: test.pushTo})
+			}
+			if args, ok := ba.GetArg(roachpb.EndTransaction); ok {
+				et := args.(*roachpb.EndTransactionRequest)
+				ets = append(ets, *et)
+				br.Txn.Status = roachpb.ABORTED
+				if et.Commit {
+					br.Txn.Status = roachpb.COMMITTED
+				}
+			}
+			return br, nil
+		}))
+		err := db.TxnWithOptions(test.opts, func(txn *Txn) error {
+			attempts++
+			for _, deadline := range test.set {
+				txn.SetDeadline(deadline)
+			}
+			return txn.Put("a", "b")
+		})
+		if attempts != 1 {
+			t.Errorf("%d: expected 1 attempt; got %d", i, attempts)
+		}
+		if len(ets) != 1 {
+			t.Fatalf("%d: expected a single EndTransaction; got %+v", i, ets)
+		}
+		if !test.expErr {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %s", i, err)
+			}
+			if !ets[0].Commit {
+				t.Errorf("%d: expected the transaction to commit", i)
+			}
+			continue
+		}
+		if _, ok := unwrapCommitError(err).(*TransactionDeadlineExceededError); !ok {
+			t.Errorf("%d: expected TransactionDeadlineExceededError; got %v", i, err)
+		}
+		if ets[0].Commit {
+			t.Errorf("%d: expected the transaction to be aborted", i)
+		}
+	}
+}
+
+// TestTxnCleanupIdempotent verifies that Cleanup stops the heartbeat
+// goroutine and aborts the transaction only once, however often it is
+// called.
+func TestTxnCleanupIdempotent(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var aborts int
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if args, ok := ba.GetArg(roachpb.EndTransaction); ok && !args.(*roachpb.EndTransactionRequest).Commit {
+			aborts++
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+
+	txn := NewTxn(*db)
+	txn.startHeartbeat(time.Millisecond)
+	if err := txn.Put("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	cause := util.Errorf("test error")
+	for i := 0; i < 3; i++ {
+		txn.Cleanup(cause)
+	}
+	if aborts != 1 {
+		t.Errorf("expected 1 abort; got %d", aborts)
+	}
+	if txn.heartbeatStop != nil {
+		t.Errorf("expected heartbeat to be stopped")
+	}
+}
+
+// TestTxnMetrics verifies that the transaction counters of a DB track
+// started, committed and aborted transactions as well as their restarts.
+func TestTxnMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var puts int
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if _, ok := ba.GetArg(roachpb.Put); ok {
+			puts++
+			switch puts {
+			case 1:
+				return nil, roachpb.NewError(roachpb.NewTransactionPushError(ba.Txn, ba.Txn))
+			case 2:
+				return nil, roachpb.NewError(roachpb.NewTransactionRetryError(ba.Txn))
+			}
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+	db.txnRetryOptions.InitialBackoff = 1 * time.Millisecond
+
+	if err := db.Txn(func(txn *Txn) error {
+		return txn.Put("a", "b")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Txn(func(txn *Txn) error {
+		if err := txn.Put("a", "b"); err != nil {
+			return err
+		}
+		return txn.Rollback()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Txn(func(txn *Txn) error {
+		b := txn.NewBatch()
+		b.Put("a", "b")
+		return txn.CommitInBatch(b)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	metrics := db.TxnMetrics()
+	if metrics.CommitNanos <= 0 {
+		t.Errorf("expected commit latency to be recorded")
+	}
+	metrics.CommitNanos = 0
+	expected := TxnMetrics{
+		Started: 3, Committed: 2, Aborted: 1,
+		Restarts: 2, RetryRestarts: 1, PushRestarts: 1, Backoffs: 1,
+		Requests: 7, SingleBatchCommits: 1,
+	}
+	if metrics != expected {
+		t.Errorf("expected metrics %+v; got %+v", expected, metrics)
+	}
+}
+
+// TestTxnReadTimestamp verifies that a transaction with a fixed read
+// timestamp sends all requests at that timestamp, even if a response
+// forwards it, and rejects writes.
+func TestTxnReadTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	readTS := roachpb.Timestamp{WallTime: 10}
+	var sent []roachpb.Timestamp
+	db := newDB(newTestSender(nil, func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		sent = append(sent, ba.Txn.OrigTimestamp)
+		br := ba.CreateReply()
+		br.Txn = proto.Clone(ba.Txn).(*roachpb.Transaction)
+		br.Txn.Timestamp.Forward(roachpb.Timestamp{WallTime: 20})
+		return br, nil
+	}))
+
+	err := db.TxnWithOptions(TxnOptions{ReadTimestamp: readTS}, func(txn *Txn) error {
+		for i := 0; i < 2; i++ {
+			if _, err := txn.Get("a"); err != nil {
+				return err
+			}
+		}
+		if err := txn.Put("a", "b"); !testutils.IsError(err, "not permitted in transaction reading at fixed timestamp") {
+			t.Errorf("expected write to be rejected; got %v", err)
+		}
+		if !txn.Proto.Timestamp.Equal(readTS) {
+			t.Errorf("expected txn timestamp %s; got %s", readTS, txn.Proto.Timestamp)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 batches to be sent; got %d", len(sent))
+	}
+	for i, ts := range sent {
+		if !ts.Equal(readTS) {
+			t.Errorf("%d: expected batch at %s; got %s", i, readTS, ts)
+		}
+	}
+}
+
+// TestTxnReadOnly verifies that a read-only transaction rejects writes
+// and commits without sending an EndTransaction.
+func TestTxnReadOnly(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var sent [][]roachpb.Method
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		sent = append(sent, ba.Methods())
+		return ba.CreateReply(), nil
+	}, nil))
+
+	err := db.TxnWithOptions(TxnOptions{ReadOnly: true}, func(txn *Txn) error {
+		if _, err := txn.Get("a"); err != nil {
+			return err
+		}
+		b := txn.NewBatch()
+		b.Get("a")
+		b.Put("b", "c")
+		if err := txn.Run(b); !testutils.IsError(err, "Put not permitted in read-only transaction") {
+			t.Errorf("expected write to be rejected; got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expSent := [][]roachpb.Method{{roachpb.Get}}; !reflect.DeepEqual(sent, expSent) {
+		t.Errorf("expected only %v to be sent; got %v", expSent, sent)
+	}
+}
+
+// TestTxnIntentBudget verifies that a batch which would exceed the
+// intent budget of a transaction fails without being sent, and that
+// writes rolled back to a savepoint no longer count against it.
+func TestTxnIntentBudget(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	testCases := []struct {
+		opts TxnOptions
+		exp  TransactionTooLargeError
+	}{
+		{TxnOptions{MaxIntents: 2}, TransactionTooLargeError{Intents: 3, IntentBytes: 6, MaxIntents: 2}},
+		{TxnOptions{MaxIntentBytes: 5}, TransactionTooLargeError{Intents: 3, IntentBytes: 6, MaxIntentBytes: 5}},
+	}
+	for i, test := range testCases {
+		var puts int
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if _, ok := ba.GetArg(roachpb.Put); ok {
+				puts++
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		err := db.TxnWithOptions(test.opts, func(txn *Txn) error {
+			sp, err := txn.Savepoint()
+			if err != nil {
+				return err
+			}
+			if err := txn.Put("x", "y"); err != nil {
+				return err
+			}
+			if err := txn.RollbackToSavepoint(sp); err != nil {
+				return err
+			}
+			for _, k := range []string{"a", "b", "c"} {
+				if err := txn.Put(k, "v"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if tErr, ok := err.(*TransactionTooLargeError); !ok || *tErr != test.exp {
+			t.Errorf("%d: expected %+v; got %v", i, test.exp, err)
+		}
+		if puts != 3 {
+			t.Errorf("%d: expected 3 puts to be sent; got %d", i, puts)
+		}
+	}
+}
+
+// TestTxnRetryOptions verifies that the retry options of a transaction
+// limit the number of times it is retried after backing off, and that
+// MaxRestarts bounds the number of restarts even when they don't back
+// off. In either case the last error is returned.
+func TestTxnRetryOptions(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	testCases := []struct {
+		opts TxnOptions
+		err  error
+	}{
+		{TxnOptions{
+			RetryOptions: &retry.Options{
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     time.Millisecond,
+				MaxRetries:     2,
+			},
+		}, &roachpb.TransactionPushError{}},
+		{TxnOptions{MaxRestarts: 2}, &roachpb.TransactionRetryError{}},
+	}
+	for i, test := range testCases {
+		var puts, retries int
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if _, ok := ba.GetArg(roachpb.Put); ok {
+				puts++
+				return nil, roachpb.NewError(test.err)
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		test.opts.OnRetry = func(int, error) {
+			retries++
+		}
+		err := db.TxnWithOptions(test.opts, func(txn *Txn) error {
+			return txn.Put("a", "b")
+		})
+		if reflect.TypeOf(err) != reflect.TypeOf(test.err) {
+			t.Fatalf("%d: expected the last %T; got %v", i, test.err, err)
+		}
+		if puts != 3 {
+			t.Errorf("%d: expected 3 attempts; got %d", i, puts)
+		}
+		if test.opts.MaxRestarts > 0 && retries != 2 {
+			t.Errorf("%d: expected 2 retries; got %d", i, retries)
+		}
+	}
+}
+
+// TestTxnContext verifies that a transaction passes its context to the
+// sender, and that once the context is canceled the transaction is
+// aborted and returns the context's error, whether the cancellation
+// happens while running the transaction function or while backing off.
+func TestTxnContext(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	type ctxKey struct{}
+	testCases := []struct {
+		cancelInBackoff bool
+		expPuts         int
+	}{
+		{false, 1},
+		{true, 2},
+	}
+	for _, test := range testCases {
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "txn"))
+		var puts int
+		var aborted bool
+		wrapped := newTestSender(nil, nil)
+		db := newDB(SenderFunc(func(c context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if args, ok := ba.GetArg(roachpb.EndTransaction); ok && !args.(*roachpb.EndTransactionRequest).Commit {
+				aborted = true
+			} else if c.Value(ctxKey{}) != "txn" {
+				t.Errorf("expected the transaction's context; got %v", c)
+			}
+			if _, ok := ba.GetArg(roachpb.Put); ok {
+				puts++
+				if test.cancelInBackoff && puts == 2 {
+					cancel()
+					return nil, roachpb.NewError(&roachpb.TransactionPushError{PusheeTxn: *ba.Txn})
+				}
+			}
+			return wrapped.Send(c, ba)
+		}))
+		opts := TxnOptions{
+			Context:      ctx,
+			RetryOptions: &retry.Options{InitialBackoff: time.Minute},
+		}
+		err := db.TxnWithOptions(opts, func(txn *Txn) error {
+			if err := txn.Put("a", "b"); err != nil {
+				return err
+			}
+			if !test.cancelInBackoff {
+				cancel()
+			}
+			return txn.Put("b", "c")
+		})
+		if err != context.Canceled {
+			t.Errorf("%t: expected %v; got %v", test.cancelInBackoff, context.Canceled, err)
+		}
+		if !aborted {
+			t.Errorf("%t: expected the transaction to be aborted", test.cancelInBackoff)
+		}
+		if puts != test.expPuts {
+			t.Errorf("%t: expected %d Puts to be sent; got %d", test.cancelInBackoff, test.expPuts, puts)
+		}
+	}
+}
+
+// TestTxnMetadata verifies that a transaction's ID, epoch and timestamp
+// are available to the transaction function and reflect restarts.
+func TestTxnMetadata(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var puts int
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if _, ok := ba.GetArg(roachpb.Put); ok {
+			puts++
+			if puts == 1 {
+				txn := ba.Txn.Clone()
+				txn.Epoch++
+				txn.Timestamp = roachpb.Timestamp{WallTime: 20}
+				return nil, roachpb.NewError(roachpb.NewTransactionRetryError(txn))
+			}
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+
+	type metadata struct {
+		id    string
+		epoch int32
+		ts    roachpb.Timestamp
+	}
+	var seen []metadata
+	if err := db.Txn(func(txn *Txn) error {
+		if len(seen) == 0 && len(txn.ID()) != 0 {
+			t.Errorf("expected no ID before the first request; got %s", txn.ID())
+		}
+		err := txn.Put("a", "b")
+		seen = append(seen, metadata{string(txn.ID()), txn.Epoch(), txn.Timestamp()})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected 2 attempts; got %d", len(seen))
+	}
+	if seen[0].id == "" || seen[1].id != seen[0].id {
+		t.Errorf("expected the same non-empty ID in both attempts; got %q and %q", seen[0].id, seen[1].id)
+	}
+	if seen[0].epoch != 1 || seen[1].epoch != 1 {
+		t.Errorf("expected epoch 1 after the restart; got %d and %d", seen[0].epoch, seen[1].epoch)
+	}
+	if expTS := (roachpb.Timestamp{WallTime: 20}); !seen[1].ts.Equal(expTS) {
+		t.Errorf("expected timestamp %s after the restart; got %s", expTS, seen[1].ts)
+	}
+}
+
+// TestTxnOnRetry verifies that the OnRetry callback is invoked for each
+// retry with the attempt number and the error which caused it, and that
+// each attempt reports whether it restarted from scratch after an abort
+// or in place after a retry error.
+func TestTxnOnRetry(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	retryErr := func(txn *roachpb.Transaction) error { return roachpb.NewTransactionRetryError(txn) }
+	abortErr := func(txn *roachpb.Transaction) error { return roachpb.NewTransactionAbortedError(txn) }
+	testCases := []struct {
+		errs           []func(*roachpb.Transaction) error // per attempt
+		expCauses      []error
+		expFromScratch []bool
+	}{
+		{
+			[]func(*roachpb.Transaction) error{retryErr, abortErr},
+			[]error{&roachpb.TransactionRetryError{}, &roachpb.TransactionAbortedError{}},
+			[]bool{false, false, true},
+		},
+		{
+			[]func(*roachpb.Transaction) error{abortErr, retryErr},
+			[]error{&roachpb.TransactionAbortedError{}, &roachpb.TransactionRetryError{}},
+			[]bool{false, true, false},
+		},
+	}
+	for i, test := range testCases {
+		var puts int
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if _, ok := ba.GetArg(roachpb.Put); ok {
+				puts++
+				if puts <= len(test.errs) {
+					return nil, roachpb.NewError(test.errs[puts-1](ba.Txn))
+				}
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		db.txnRetryOptions.InitialBackoff = 1 * time.Millisecond
+
+		var attempts []int
+		var causes []error
+		var fromScratch []bool
+		opts := TxnOptions{
+			OnRetry: func(attempt int, cause error) {
+				attempts = append(attempts, attempt)
+				causes = append(causes, cause)
+			},
+		}
+		if err := db.TxnWithOptions(opts, func(txn *Txn) error {
+			fromScratch = append(fromScratch, txn.RestartedFromScratch())
+			return txn.Put("a", "b")
+		}); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if expected := []int{1, 2}; !reflect.DeepEqual(attempts, expected) {
+			t.Fatalf("%d: expected retries after attempts %v; got %v", i, expected, attempts)
+		}
+		for j, cause := range causes {
+			if reflect.TypeOf(cause) != reflect.TypeOf(test.expCauses[j]) {
+				t.Errorf("%d: expected %T for retry %d; got %T", i, test.expCauses[j], j+1, cause)
+			}
+		}
+		if !reflect.DeepEqual(fromScratch, test.expFromScratch) {
+			t.Errorf("%d: expected restarts from scratch %v; got %v", i, test.expFromScratch, fromScratch)
+		}
+	}
+}
+
+// TestTxnSetIsolation verifies that transactions default to serializable
+// isolation and that unknown isolation types are rejected.
+func TestTxnSetIsolation(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	db := newDB(newTestSender(nil, nil))
+	txn := NewTxn(*db)
+	if iso := txn.Proto.Isolation; iso != roachpb.SERIALIZABLE {
+		t.Errorf("expected default isolation SERIALIZABLE; got %s", iso)
+	}
+	if err := txn.SetIsolation(roachpb.SNAPSHOT); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.SetIsolation(roachpb.IsolationType(42)); !testutils.IsError(err, "unknown isolation type 42") {
+		t.Errorf("expected unknown isolation error; got %v", err)
+	}
+	if iso := txn.Proto.Isolation; iso != roachpb.SNAPSHOT {
+		t.Errorf("expected isolation SNAPSHOT; got %s", iso)
+	}
+}
+
+// TestTxnSenderMissingResponse verifies that a sender which returns
+// neither a response nor an error yields an error instead of a crash.
+func TestTxnSenderMissingResponse(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	db := newDB(SenderFunc(func(context.Context, roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		return nil, nil
+	}))
+	const expErr = "returned neither a response nor an error"
+	if err := db.Put("a", "b"); !testutils.IsError(err, expErr) {
+		t.Errorf("expected error %q; got %v", expErr, err)
+	}
+	if err := db.Txn(func(txn *Txn) error {
+		return txn.Put("a", "b")
+	}); !testutils.IsError(err, expErr) {
+		t.Errorf("expected error %q; got %v", expErr, err)
+	}
+}
+
+// TestTxnSynchronousHelpers verifies that Get, Put and Scan block for
+// their replies, return decoded values and send their requests as part
+// of the transaction, with client command IDs on writes.
+func TestTxnSynchronousHelpers(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var txnIDs [][]byte
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if ba.Txn == nil {
+			return nil, roachpb.NewError(util.Errorf("%s sent without transaction", ba))
+		}
+		if ba.IsWrite() && ba.CmdID == (roachpb.ClientCmdID{}) {
+			return nil, roachpb.NewError(util.Errorf("%s sent without client command ID", ba))
+		}
+		txnIDs = append(txnIDs, ba.Txn.ID)
+		br := ba.CreateReply()
+		value := roachpb.Value{Bytes: []byte("value")}
+		if _, ok := ba.GetArg(roachpb.Get); ok {
+			br.Responses[0].GetInner().(*roachpb.GetResponse).Value = &value
+		}
+		if args, ok := ba.GetArg(roachpb.Scan); ok {
+			br.Responses[0].GetInner().(*roachpb.ScanResponse).Rows = []roachpb.KeyValue{
+				{Key: args.Header().Key, Value: value},
+			}
+		}
+		return br, nil
+	}, nil))
+
+	if err := db.Txn(func(txn *Txn) error {
+		if err := txn.Put("a", "value"); err != nil {
+			return err
+		}
+		kv, err := txn.Get("a")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(kv.ValueBytes(), []byte("value")) {
+			t.Errorf("expected Get to return %q; got %q", "value", kv.ValueBytes())
+		}
+		rows, err := txn.Scan("a", "b", 0)
+		if err != nil {
+			return err
+		}
+		if len(rows) != 1 || !bytes.Equal(rows[0].ValueBytes(), []byte("value")) {
+			t.Errorf("expected Scan to return a single row with value %q; got %v", "value", rows)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Put, Get, Scan and the commit.
+	if len(txnIDs) != 4 {
+		t.Fatalf("expected 4 batches; got %d", len(txnIDs))
+	}
+	for i, id := range txnIDs {
+		if !bytes.Equal(id, txnIDs[0]) {
+			t.Errorf("%d: expected txn ID %x; got %x", i, txnIDs[0], id)
+		}
+	}
+}
+
+// TestTxnPriorityPolicy verifies that the priority with which an aborted
+// transaction restarts is determined by its priority policy, and that
+// the final priority is visible to the caller.
+func TestTxnPriorityPolicy(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	abortPriorities := []int32{10, 50, 30}
+	testCases := []struct {
+		policy        PriorityPolicy
+		expPriorities []int32
+	}{
+		{nil, []int32{0, 10, 50, 50}},
+		{MatchConflicting, []int32{0, 10, 50, 50}},
+		{CappedPriority(20), []int32{0, 10, 20, 20}},
+		{NoEscalation, []int32{0, 0, 0, 0}},
+	}
+	for i, test := range testCases {
+		var aborts int
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if _, ok := ba.GetArg(roachpb.Put); ok && aborts < len(abortPriorities) {
+				abortTxn := *proto.Clone(ba.Txn).(*roachpb.Transaction)
+				abortTxn.Priority = abortPriorities[aborts]
+				aborts++
+				return nil, roachpb.NewError(&roachpb.TransactionAbortedError{Txn: abortTxn})
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		db.txnRetryOptions.InitialBackoff = 1 * time.Millisecond
+
+		var priorities []int32
+		var last *Txn
+		if err := db.TxnWithOptions(TxnOptions{PriorityPolicy: test.policy}, func(txn *Txn) error {
+			last = txn
+			priorities = append(priorities, txn.Priority())
+			return txn.Put("a", "b")
+		}); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !reflect.DeepEqual(priorities, test.expPriorities) {
+			t.Errorf("%d: expected priorities %v; got %v", i, test.expPriorities, priorities)
+		}
+		if exp := test.expPriorities[len(test.expPriorities)-1]; last.Priority() != exp {
+			t.Errorf("%d: expected final priority %d; got %d", i, exp, last.Priority())
+		}
+	}
+}
+
+// TestTxnDelRangeMax verifies that DelRangeMax sends a limited
+// DeleteRange as a tracked write of the transaction and returns the
+// number of rows deleted.
+func TestTxnDelRangeMax(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		br := ba.CreateReply()
+		if args, ok := ba.GetArg(roachpb.DeleteRange); ok {
+			if ba.CmdID == (roachpb.ClientCmdID{}) {
+				return nil, roachpb.NewError(util.Errorf("%s sent without client command ID", ba))
+			}
+			dr := args.(*roachpb.DeleteRangeRequest)
+			br.Responses[0].GetInner().(*roachpb.DeleteRangeResponse).NumDeleted = dr.MaxEntriesToDelete
+		}
+		return br, nil
+	}, nil))
+
+	if err := db.Txn(func(txn *Txn) error {
+		n, err := txn.DelRangeMax("a", "z", 3)
+		if err != nil {
+			return err
+		}
+		if n != 3 {
+			t.Errorf("expected 3 rows deleted; got %d", n)
+		}
+		expWrites := []writeSpan{{key: roachpb.Key("a"), endKey: roachpb.Key("z"), size: 2}}
+		if !reflect.DeepEqual(txn.writes, expWrites) {
+			t.Errorf("expected writes %v; got %v", expWrites, txn.writes)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTxnParallelReadsRacingCommit verifies that reads racing with the
+// commit of a transaction with ParallelReads set either complete before
+// the commit is sent or fail with TransactionStatusError, and are
+// never sent on behalf of the finalized transaction.
+func TestTxnParallelReadsRacingCommit(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	const numReads = 50
+	for i := 0; i < 10; i++ {
+		var mu sync.Mutex
+		var committed bool
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if _, ok := ba.GetArg(roachpb.EndTransaction); ok {
+				// Give the reads time to queue up behind the commit after
+				// having found the transaction pending.
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				committed = true
+				mu.Unlock()
+				return ba.CreateReply(), nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if committed {
+				t.Errorf("%s sent after commit", ba)
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		txn := NewTxn(*db)
+		txn.parallelReads = true
+		if err := txn.Put("a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		wg.Add(numReads)
+		for j := 0; j < numReads; j++ {
+			go func() {
+				defer wg.Done()
+				if _, err := txn.Get("a"); err != nil {
+					if _, ok := err.(*roachpb.TransactionStatusError); !ok {
+						t.Errorf("expected TransactionStatusError; got %v", err)
+					}
+				}
+			}()
+		}
+		if err := txn.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+	}
+}
+
+// TestTxnTracer verifies that with a Tracer set, each batch sent by a
+// transaction is traced with its methods, epoch and attempt, that the
+// trace is passed to the wrapped sender and that a batch which causes
+// the transaction to back off is annotated accordingly.
+func TestTxnTracer(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	stopper := stop.NewStopper()
+	defer stopper.Stop()
+	feed := util.NewFeed(stopper)
+	var traces [][]string
+	feed.Subscribe(func(event interface{}) {
+		var names []string
+		for _, item := range event.(*tracer.Trace).Content {
+			names = append(names, item.Name)
+		}
+		traces = append(traces, names)
+	})
+
+	var puts int
+	sender := newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if _, ok := ba.GetArg(roachpb.Put); ok {
+			if puts++; puts == 1 {
+				return nil, roachpb.NewError(roachpb.NewTransactionPushError(ba.Txn, &roachpb.Transaction{}))
+			}
+		}
+		return ba.CreateReply(), nil
+	}, nil)
+	db := newDB(SenderFunc(func(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if tracer.FromCtx(ctx) == nil {
+			t.Errorf("%s sent without trace", ba)
+		}
+		return sender.Send(ctx, ba)
+	}))
+	opts := TxnOptions{Tracer: tracer.NewTracer(feed, "test")}
+	if err := db.TxnWithOptions(opts, func(txn *Txn) error {
+		return txn.Put("a", "b")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	feed.Flush()
+
+	expTraces := [][]string{
+		{"[Put] (epoch 0, attempt 1)", "backing off"},
+		{"[Put] (epoch 0, attempt 2)"},
+		{"[EndTransaction] (epoch 0, attempt 2)"},
+	}
+	if len(traces) != len(expTraces) {
+		t.Fatalf("expected %d traces; got %v", len(expTraces), traces)
+	}
+	for i, exp := range expTraces {
+		if len(traces[i]) != len(exp) {
+			t.Errorf("%d: expected %v; got %v", i, exp, traces[i])
+			continue
+		}
+		for j := range exp {
+			if !strings.HasPrefix(traces[i][j], exp[j]) {
+				t.Errorf("%d: expected %v; got %v", i, exp, traces[i])
+			}
+		}
+	}
+}
+
+// TestTxnCommitTimestamp verifies that the commit timestamp is only
+// reported once the transaction has committed and reflects the pushed
+// timestamp of the transaction.
+func TestTxnCommitTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	pushedTS := roachpb.Timestamp{WallTime: 10}
+	db := newDB(newTestSender(nil, func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		br := ba.CreateReply()
+		br.Txn = ba.Txn.Clone()
+		br.Txn.Writing = true
+		if _, ok := ba.GetArg(roachpb.Put); ok {
+			br.Txn.Timestamp.Forward(pushedTS)
+		}
+		if _, ok := ba.GetArg(roachpb.EndTransaction); ok {
+			br.Txn.Status = roachpb.COMMITTED
+		}
+		return br, nil
+	}))
+	ts, err := db.TxnReturningTimestamp(func(txn *Txn) error {
+		if err := txn.Put("a", "b"); err != nil {
+			return err
+		}
+		if ts := txn.CommitTimestamp(); ts != roachpb.ZeroTimestamp {
+			t.Errorf("expected zero commit timestamp before commit; got %s", ts)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ts.Equal(pushedTS) {
+		t.Errorf("expected commit timestamp %s; got %s", pushedTS, ts)
+	}
+}
+
+// TestTxnPendingIntents verifies that PendingIntents returns the spans
+// written by the current attempt of a transaction, excluding those
+// rolled back to a savepoint or written by an earlier attempt.
+func TestTxnPendingIntents(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		return ba.CreateReply(), nil
+	}, nil))
+	spans := func(intents []roachpb.Intent) []writeSpan {
+		var spans []writeSpan
+		for _, intent := range intents {
+			spans = append(spans, writeSpan{key: intent.Key, endKey: intent.EndKey})
+		}
+		return spans
+	}
+	expSpans := []writeSpan{
+		{key: roachpb.Key("a")},
+		{key: roachpb.Key("b")},
+		{key: roachpb.Key("c"), endKey: roachpb.Key("e")},
+	}
+	var attempts int
+	if err := db.Txn(func(txn *Txn) error {
+		attempts++
+		if intents := txn.PendingIntents(); len(intents) != 0 {
+			t.Errorf("%d: expected no intents at start of attempt; got %v", attempts, intents)
+		}
+		if err := txn.Put("a", "1"); err != nil {
+			return err
+		}
+		b := txn.NewBatch()
+		b.Put("b", "2")
+		b.DelRange("c", "e")
+		if err := txn.Run(b); err != nil {
+			return err
+		}
+		sp, err := txn.Savepoint()
+		if err != nil {
+			return err
+		}
+		if err := txn.Put("f", "3"); err != nil {
+			return err
+		}
+		if err := txn.RollbackToSavepoint(sp); err != nil {
+			return err
+		}
+		intents := txn.PendingIntents()
+		if actSpans := spans(intents); !reflect.DeepEqual(actSpans, expSpans) {
+			t.Errorf("%d: expected intents %v; got %v", attempts, expSpans, actSpans)
+		}
+		for _, intent := range intents {
+			if !roachpb.TxnIDEqual(intent.Txn.ID, txn.Proto.ID) {
+				t.Errorf("%d: expected intent of txn %s; got %s", attempts, txn.Proto.ID, intent.Txn.ID)
+			}
+		}
+		if attempts == 1 {
+			return &roachpb.TransactionRetryError{Txn: txn.Proto}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts; got %d", attempts)
+	}
+}
+
+// TestTxnAbortIf verifies that a transaction marked by AbortIf is
+// rolled back with the given reason instead of committed, that it is
+// committed otherwise, and that the mark doesn't carry over to a retry.
+func TestTxnAbortIf(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	testCases := []struct {
+		conds     []bool // per attempt
+		expCommit bool
+	}{
+		{[]bool{false}, true},
+		{[]bool{true}, false},
+		{[]bool{true, false}, true},
+		{[]bool{false, true}, false},
+	}
+	for i, test := range testCases {
+		var ets []roachpb.EndTransactionRequest
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if args, ok := ba.GetArg(roachpb.EndTransaction); ok {
+				ets = append(ets, *args.(*roachpb.EndTransactionRequest))
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		var attempt int
+		var last *Txn
+		if err := db.Txn(func(txn *Txn) error {
+			last = txn
+			cond := test.conds[attempt]
+			attempt++
+			if err := txn.Put("a", "b"); err != nil {
+				return err
+			}
+			if err := txn.AbortIf(cond, "test"); err != nil {
+				return err
+			}
+			if attempt < len(test.conds) {
+				return &roachpb.TransactionRetryError{Txn: txn.Proto}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(ets) != 1 {
+			t.Fatalf("%d: expected 1 EndTransaction; got %d", i, len(ets))
+		}
+		if ets[0].Commit != test.expCommit {
+			t.Errorf("%d: expected commit=%t; got %t", i, test.expCommit, ets[0].Commit)
+		}
+		var expReason, reason string
+		if !test.expCommit {
+			expReason = "test"
+		}
+		if last.abortReason != nil {
+			reason = *last.abortReason
+		}
+		if reason != expReason {
+			t.Errorf("%d: expected abort reason %q; got %q", i, expReason, reason)
+		}
+	}
+}
+
+// TestTxnPanic verifies that a transaction whose function panics is
+// aborted before the panic reaches the
+// caller.
+func TestTxnPanic(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var ets []roachpb.EndTransactionRequest
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if args, ok := ba.GetArg(roachpb.EndTransaction); ok {
+			ets = append(ets, *args.(*roachpb.EndTransactionRequest))
+		}
+		return ba.CreateReply(), nil
+	}, nil))
+
+	var panicked *Txn
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("expected panic %q; got %v", "boom", r)
+			}
+			if len(ets) != 1 {
+				t.Fatalf("expected 1 EndTransaction before the panic surfaced; got %d", len(ets))
+			}
+			if ets[0].Commit {
+				t.Error("expected the transaction to be aborted")
+			}
+			if panicked.abortReason == nil || !strings.Contains(*panicked.abortReason, "panicked: boom") {
+				t.Errorf("expected the abort reason to mention the panic; got %v", panicked.abortReason)
+			}
+		}()
+		_ = db.Txn(func(txn *Txn) error {
+			panicked = txn
+			if err := txn.Put("a", "b"); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+}
+
+// TestTxnInconsistentBatch verifies that an inconsistent batch run in a
+// transaction is sent outside of it, leaving the transaction's
+// timestamp alone, and that it may only contain reads.
+func TestTxnInconsistentBatch(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var bas []roachpb.BatchRequest
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		bas = append(bas, ba)
+		br := ba.CreateReply()
+		if ba.ReadConsistency == roachpb.INCONSISTENT {
+			br.Timestamp = roachpb.ZeroTimestamp.Add(10, 0)
+		}
+		return br, nil
+	}, nil))
+
+	if err := db.Txn(func(txn *Txn) error {
+		if err := txn.Put("a", "b"); err != nil {
+			return err
+		}
+		before := txn.Proto.Timestamp
+
+		b := txn.NewBatch()
+		b.ReadConsistency = roachpb.INCONSISTENT
+		b.Get("a")
+		b.Scan("a", "c", 0)
+		if err := txn.Run(b); err != nil {
+			return err
+		}
+		if !txn.Proto.Timestamp.Equal(before) {
+			t.Errorf("expected inconsistent reads to leave txn timestamp at %s; got %s", before, txn.Proto.Timestamp)
+		}
+
+		b = txn.NewBatch()
+		b.ReadConsistency = roachpb.INCONSISTENT
+		b.Put("a", "c")
+		if err := txn.Run(b); !testutils.IsError(err, "Put not permitted in INCONSISTENT batch") {
+			t.Errorf("expected inconsistent write to be rejected; got %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Put, the inconsistent reads and the EndTransaction.
+	if len(bas) != 3 {
+		t.Fatalf("expected 3 batches; got %d", len(bas))
+	}
+	ba := bas[1]
+	if ba.Txn != nil {
+		t.Errorf("expected inconsistent batch to be sent without txn; got %s", ba.Txn)
+	}
+	if ba.ReadConsistency != roachpb.INCONSISTENT {
+		t.Errorf("expected inconsistent batch; got %s", ba.ReadConsistency)
+	}
+	for _, union := range ba.Requests {
+		if args := union.GetInner(); args.Header().ReadConsistency != roachpb.INCONSISTENT {
+			t.Errorf("expected inconsistent %s; got %s", args.Method(), args.Header().ReadConsistency)
+		}
+	}
+	for i, ba := range []roachpb.BatchRequest{bas[0], bas[2]} {
+		if ba.Txn == nil || ba.ReadConsistency != roachpb.CONSISTENT {
+			t.Errorf("%d: expected consistent transactional batch; got %s", i, ba)
+		}
+	}
+}
+
+// TestTxnSenderMiddleware verifies that transactions can be run
+// through a Sender layered on top of another one, which sees each of
+// the transaction's batches, and that the transaction observes the
+// responses as passed back by the middleware.
+func TestTxnSenderMiddleware(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var methods []string
+	wrapped := newTestSender(nil, nil)
+	db := newDB(SenderFunc(func(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if ba.Txn == nil {
+			t.Errorf("expected transactional batch; got %s", ba)
+		}
+		methods = append(methods, fmt.Sprint(ba.Methods()))
+		br, pErr := wrapped.Send(ctx, ba)
+		if br != nil && br.Txn != nil {
+			br.Txn.Priority = 42
+		}
+		return br, pErr
+	}))
+
+	if err := db.Txn(func(txn *Txn) error {
+		if err := txn.Put("a", "b"); err != nil {
+			return err
+		}
+		if txn.Proto.Priority != 42 {
+			t.Errorf("expected txn to observe the middleware's response; got priority %d", txn.Proto.Priority)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if expMethods := []string{"[Put]", "[EndTransaction]"}; !reflect.DeepEqual(methods, expMethods) {
+		t.Errorf("expected middleware to see %v; got %v", expMethods, methods)
+	}
+}
+
+// TestTxnAnchorKey verifies that a transaction begun with an anchor
+// key asks for its record to be anchored there, also when it is
+// restarted after an abort, and that the first request's key is left
+// to the coordinator otherwise.
+func TestTxnAnchorKey(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	for _, anchorKey := range []roachpb.Key{nil, roachpb.Key("z")} {
+		var begins []roachpb.Key
+		var aborted bool
+		wrapped := newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if !aborted {
+				aborted = true
+				return nil, roachpb.NewError(&roachpb.TransactionAbortedError{Txn: *ba.Txn})
+			}
+			return ba.CreateReply(), nil
+		}, nil)
+		db := newDB(SenderFunc(func(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if len(ba.Txn.ID) == 0 {
+				begins = append(begins, ba.Txn.Key)
+			}
+			return wrapped.Send(ctx, ba)
+		}))
+		if err := db.TxnWithOptions(TxnOptions{AnchorKey: anchorKey}, func(txn *Txn) error {
+			return txn.Put("a", "b")
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if expBegins := []roachpb.Key{anchorKey, anchorKey}; !reflect.DeepEqual(begins, expBegins) {
+			t.Errorf("expected transaction to begin anchored at %v; got %v", expBegins, begins)
+		}
+	}
+}
+
+// TestTxnSwallowedRetry verifies that a SERIALIZABLE transaction whose
+// function returns successfully after one of its operations failed with
+// a retry error is counted in the metrics, and that other transactions
+// are not.
+func TestTxnSwallowedRetry(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	testCases := []struct {
+		isolation   roachpb.IsolationType
+		returnErr   bool
+		expSwallows int64
+	}{
+		{roachpb.SERIALIZABLE, false, 1},
+		{roachpb.SERIALIZABLE, true, 0},
+		{roachpb.SNAPSHOT, false, 0},
+	}
+	for i, test := range testCases {
+		var puts int
+		db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+			if _, ok := ba.GetArg(roachpb.Put); ok {
+				if puts++; puts == 1 {
+					return nil, roachpb.NewError(roachpb.NewTransactionRetryError(ba.Txn))
+				}
+			}
+			return ba.CreateReply(), nil
+		}, nil))
+		if err := db.Txn(func(txn *Txn) error {
+			if err := txn.SetIsolation(test.isolation); err != nil {
+				return err
+			}
+			if err := txn.Put("a", "b"); err != nil && test.returnErr {
+				return err
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if swallows := db.TxnMetrics().SwallowedRetries; swallows != test.expSwallows {
+			t.Errorf("%d: expected %d swallowed retries; got %d", i, test.expSwallows, swallows)
+		}
+	}
+}
+
+// BenchmarkTxnGet benchmarks the client-side overhead of a Get within a
+// transaction, against a sender which replies immediately.
+func BenchmarkTxnGet(b *testing.B) {
+	db := newDB(newTestSender(func(ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		return ba.CreateReply(), nil
+	}, nil))
+	txn := NewTxn(*db)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := txn.Get("a"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
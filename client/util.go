@@ -18,6 +18,8 @@
 package client
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
 	"reflect"
 	"time"
@@ -110,3 +112,14 @@ func marshalValue(v interface{}) (roachpb.Value, error) {
 
 	return r, fmt.Errorf("unable to marshal value: %v", v)
 }
+
+// marshalObject returns a roachpb.Value holding the gob encoding of obj.
+func marshalObject(obj interface{}) (roachpb.Value, error) {
+	var r roachpb.Value
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(obj); err != nil {
+		return r, err
+	}
+	r.SetBytes(buf.Bytes())
+	return r, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package client
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/cockroachdb/cockroach/roachpb"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// A WatchEvent describes a committed update to the keys being watched.
+type WatchEvent struct {
+	// Key is the updated key. If EndKey is set, the update affected an
+	// unknown subset of the keys in [Key,EndKey), for example because
+	// they were deleted by a DeleteRange, and their values need to be
+	// read again.
+	Key, EndKey roachpb.Key
+	// Value is the value of Key as of the update, or nil if the key was
+	// deleted. It's always nil if EndKey is set.
+	Value *roachpb.Value
+	// Timestamp is the timestamp at which the update was committed.
+	Timestamp roachpb.Timestamp
+}
+
+func (e WatchEvent) String() string {
+	if e.EndKey != nil {
+		return fmt.Sprintf("[%s,%s) updated at %s", e.Key, e.EndKey, e.Timestamp)
+	}
+	return fmt.Sprintf("%s updated at %s", e.Key, e.Timestamp)
+}
+
+// A Watcher is implemented by Senders which can stream the committed
+// updates to a span of keys.
+type Watcher interface {
+	// Watch returns a channel which receives the updates committed to
+	// keys in [start,end) after the call. The channel is closed once ctx
+	// is done, or before that if the receiver falls behind so far that
+	// updates had to be dropped.
+	Watch(ctx context.Context, start, end roachpb.Key) (<-chan WatchEvent, error)
+}
+
+// Watch streams the updates committed to keys between begin (inclusive)
+// and end (exclusive) from now on, until ctx is done. It returns an
+// error if the DB's sender doesn't support watches.
+//
+// An update may be delivered more than once, and updates to the same
+// key from different replicas may arrive out of order; receivers should
+// use the Timestamp of each event to discard stale ones. If the channel
+// is closed while ctx isn't done yet, updates have been dropped: the
+// receiver must read the span again and start a new watch.
+//
+// key can be either a byte slice or a string.
+func (db *DB) Watch(ctx context.Context, begin, end interface{}) (<-chan WatchEvent, error) {
+	w, ok := db.sender.(Watcher)
+	if !ok {
+		return nil, util.Errorf("%T does not support watches", db.sender)
+	}
+	start, err := marshalKey(begin)
+	if err != nil {
+		return nil, err
+	}
+	endKey, err := marshalKey(end)
+	if err != nil {
+		return nil, err
+	}
+	return w.Watch(ctx, start, endKey)
+}
@@ -130,21 +130,26 @@ func newChunkingSender(f senderFn) client.Sender {
 	return &chunkingSender{f: f}
 }
 
+// errNo1PCTxn is returned by the function wrapped in a chunkingSender
+// when it is handed a chunk which ends in an EndTransaction but spans
+// several ranges. Nothing of the chunk has been sent at that point.
+var errNo1PCTxn = roachpb.NewError(util.Errorf("cannot send 1PC txn to multiple ranges"))
+
 // Send implements Sender.
-// TODO(tschottdorf): We actually don't want to chop EndTransaction off for
-// single-range requests (but that happens now since EndTransaction has the
-// isAlone flag). Whether it is one or not is unknown right now (you can only
-// find out after you've sent to the Range/looked up a descriptor that suggests
-// that you're multi-range. In those cases, the wrapped sender should return an
-// error so that we split and retry once the chunk which contains
-// EndTransaction (i.e. the last one).
+// An EndTransaction is kept in the same chunk as the writes preceding it,
+// so that a transaction which only writes to a single range commits in
+// one round trip. Whether it does is unknown until the wrapped sender
+// has looked up the range descriptors. If the chunk turns out to span
+// several ranges, the wrapped sender returns errNo1PCTxn and the chunk
+// is sent again with the EndTransaction split off.
 func (cs *chunkingSender) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
 	if len(ba.Requests) < 1 {
 		panic("empty batch")
 	}
 
-	// Deterministically create ClientCmdIDs for all parts of the batch if
-	// a CmdID is already set (otherwise, leave them empty).
+	// Deterministically create ClientCmdIDs for all mutating parts of the
+	// batch if a CmdID is already set (otherwise, leave them empty). Read-only
+	// parts don't need idempotency protection and are sent without one.
 	var nextID func() roachpb.ClientCmdID
 	empty := roachpb.ClientCmdID{}
 	if empty == ba.CmdID {
@@ -161,15 +166,28 @@ func (cs *chunkingSender) Send(ctx context.Context, ba roachpb.BatchRequest) (*r
 		}
 	}
 
-	parts := ba.Split()
+	parts := ba.Split(false /* don't split ET */)
 	var rplChunks []*roachpb.BatchResponse
-	for _, part := range parts {
+	for len(parts) > 0 {
+		part := parts[0]
 		ba.Requests = part
+		// Always advance the ID sequence so that the IDs assigned to the
+		// mutating parts don't depend on the read-only parts.
 		ba.CmdID = nextID()
+		if ba.IsReadOnly() {
+			ba.CmdID = empty
+		}
 		rpl, err := cs.f(ctx, ba)
+		if err == errNo1PCTxn {
+			// The chunk spans several ranges, so its EndTransaction has to
+			// go out on its own once the writes are done.
+			parts = append(ba.Split(true /* split ET */), parts[1:]...)
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
+		parts = parts[1:]
 		// Propagate transaction from last reply to next request. The final
 		// update is taken and put into the response's main header.
 		ba.Txn.Update(rpl.Header().Txn)
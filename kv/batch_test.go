@@ -19,8 +19,11 @@ package kv
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 
+	"golang.org/x/net/context"
+
 	"github.com/cockroachdb/cockroach/keys"
 	"github.com/cockroachdb/cockroach/roachpb"
 	"github.com/cockroachdb/cockroach/util/leaktest"
@@ -71,3 +74,26 @@ func TestBatchPrevNext(t *testing.T) {
 		}
 	}
 }
+
+// TestChunkingSenderCmdIDs verifies that the chunking sender only
+// assigns client command IDs to the mutating parts of a batch.
+func TestChunkingSenderCmdIDs(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	var empty []bool
+	cs := newChunkingSender(func(_ context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		empty = append(empty, ba.CmdID.IsEmpty())
+		return ba.CreateReply(), nil
+	})
+	var ba roachpb.BatchRequest
+	ba.Txn = &roachpb.Transaction{}
+	ba.CmdID = roachpb.ClientCmdID{WallTime: 1, Random: 1}
+	ba.Add(&roachpb.GetRequest{RequestHeader: roachpb.RequestHeader{Key: roachpb.Key("a")}})
+	ba.Add(&roachpb.PutRequest{RequestHeader: roachpb.RequestHeader{Key: roachpb.Key("b")}})
+	ba.Add(&roachpb.GetRequest{RequestHeader: roachpb.RequestHeader{Key: roachpb.Key("c")}})
+	if _, pErr := cs.Send(context.Background(), ba); pErr != nil {
+		t.Fatal(pErr)
+	}
+	if expected := []bool{true, false, true}; !reflect.DeepEqual(expected, empty) {
+		t.Errorf("expected empty command IDs %v; got %v", expected, empty)
+	}
+}
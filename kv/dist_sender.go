@@ -20,6 +20,7 @@ package kv
 import (
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -54,6 +55,9 @@ const (
 	defaultLeaderCacheSize = 1 << 16
 	// The default size of the range descriptor cache.
 	defaultRangeDescriptorCacheSize = 1 << 20
+	// The maximum number of ranges to which parts of a single batch are
+	// sent in parallel.
+	maxParallelRanges = 16
 )
 
 var defaultRPCRetryOptions = retry.Options{
@@ -233,6 +237,12 @@ func (ds *DistSender) rangeLookup(key roachpb.Key, options lookupOptions,
 	return br.Responses[0].GetInner().(*roachpb.RangeLookupResponse).Ranges, nil
 }
 
+// RangeDescriptorCacheMetrics returns a snapshot of the counters of the
+// DistSender's range descriptor cache.
+func (ds *DistSender) RangeDescriptorCacheMetrics() RangeDescriptorCacheMetrics {
+	return ds.rangeCache.metrics()
+}
+
 // firstRange returns the RangeDescriptor for the first range on the cluster,
 // which is retrieved from the gossip protocol instead of the datastore.
 func (ds *DistSender) firstRange() (*roachpb.RangeDescriptor, error) {
@@ -477,16 +487,134 @@ func (ds *DistSender) sendChunk(ctx context.Context, ba roachpb.BatchRequest) (*
 	// making sure that anything that relies on them goes bust.
 	ba.Key, ba.EndKey = nil, nil
 
-	isReverse := ba.IsReverse()
-
-	trace := tracer.FromCtx(ctx)
-
 	// The minimal key range encompassing all requests contained within.
 	// Local addressing has already been resolved.
 	// TODO(tschottdorf): consider rudimentary validation of the batch here
 	// (for example, non-range requests with EndKey, or empty key ranges).
 	from, to := keys.Range(ba)
+	if spans := ds.parallelSpans(ba, from, to); len(spans) > 1 {
+		return ds.sendParallel(ctx, ba, spans)
+	}
+	return ds.sendRange(ctx, ba, from, to)
+}
+
+// parallelSpans splits the key range [from,to) of the given batch at the
+// boundaries of the ranges it spans, as far as they are known to the
+// range descriptor cache, so that the pieces can be sent in parallel.
+// At most maxParallelRanges spans are returned; the last one covers
+// whatever remains of [from,to). Nil is returned if the batch should be
+// sent one range at a time, i.e. if it fits in a single range, if it
+// contains an EndTransaction, or if it contains a bounded request whose
+// results depend on those returned by the ranges before it.
+//
+// The descriptors are looked up one after the other before anything is
+// sent. They usually come from the cache, but each miss costs a range
+// lookup, so a batch spanning many uncached ranges waits for those
+// lookups in sequence, just as it would when sent one range at a time.
+func (ds *DistSender) parallelSpans(ba roachpb.BatchRequest, from, to roachpb.Key) []keys.Span {
+	if ba.Txn == nil && ba.ReadConsistency != roachpb.INCONSISTENT {
+		// Leave it to sendRange to return OpRequiresTxnError if needed.
+		return nil
+	}
+	if _, ok := ba.GetArg(roachpb.EndTransaction); ok {
+		// Leave it to sendRange to hand the EndTransaction back if the
+		// batch spans ranges.
+		return nil
+	}
+	for _, union := range ba.Requests {
+		if b, ok := union.GetInner().(roachpb.Bounded); ok && b.GetBound() > 0 {
+			return nil
+		}
+	}
+	var spans []keys.Span
+	for len(spans) < maxParallelRanges-1 {
+		desc, needAnother, _, pErr := ds.getDescriptors(from, to, lookupOptions{})
+		if pErr != nil || !needAnother || !desc.ContainsKey(from) {
+			break
+		}
+		spans = append(spans, keys.Span{Start: from, End: desc.EndKey})
+		if from = next(ba, desc.EndKey); !from.Less(to) {
+			return spans
+		}
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	return append(spans, keys.Span{Start: from, End: to})
+}
+
+// sendParallel sends the parts of the batch which fall into each of
+// the given spans concurrently and recombines the replies in the order
+// in which they would have been received had the spans been visited one
+// after the other. If several parts fail, the error of the first one in
+// that order is returned.
+func (ds *DistSender) sendParallel(ctx context.Context, ba roachpb.BatchRequest, spans []keys.Span) (*roachpb.BatchResponse, *roachpb.Error) {
+	trace := tracer.FromCtx(ctx)
+	trace.Event(fmt.Sprintf("sending to %d ranges in parallel", len(spans)))
+	// Each part gets its own copy of the requests, confined to its span,
+	// since truncating them during the send modifies them in place. All
+	// parts are prepared before any is sent, so that a failure doesn't
+	// leave sends running.
+	parts := make([]roachpb.BatchRequest, len(spans))
+	for i, span := range spans {
+		part := ba
+		part.Requests = make([]roachpb.RequestUnion, len(ba.Requests))
+		for j, union := range ba.Requests {
+			part.Requests[j].SetValue(proto.Clone(union.GetInner()).(roachpb.Request))
+		}
+		spanDesc := &roachpb.RangeDescriptor{StartKey: span.Start, EndKey: span.End}
+		if _, _, err := truncate(&part, spanDesc, span.Start, span.End); err != nil {
+			return nil, roachpb.NewError(err)
+		}
+		parts[i] = part
+	}
+	replies := make([]*roachpb.BatchResponse, len(spans))
+	errs := make([]*roachpb.Error, len(spans))
+	var wg sync.WaitGroup
+	for i, span := range spans {
+		wg.Add(1)
+		go func(i int, span keys.Span) {
+			defer wg.Done()
+			partTrace := trace.Fork()
+			defer partTrace.Finalize()
+			replies[i], errs[i] = ds.sendRange(tracer.ToCtx(ctx, partTrace), parts[i], span.Start, span.End)
+		}(i, span)
+	}
+	wg.Wait()
+
+	order := make([]int, len(spans))
+	for i := range order {
+		order[i] = i
+		if ba.IsReverse() {
+			order[i] = len(spans) - 1 - i
+		}
+	}
+	var br *roachpb.BatchResponse
+	for _, i := range order {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if br == nil {
+			br = replies[i]
+		} else if err := br.Combine(replies[i]); err != nil {
+			return nil, roachpb.NewError(err)
+		}
+	}
+	return br, nil
+}
+
+// sendRange sends the batch to the ranges spanning [from,to), one range
+// at a time, and recombines the replies.
+func (ds *DistSender) sendRange(ctx context.Context, ba roachpb.BatchRequest, from, to roachpb.Key) (*roachpb.BatchResponse, *roachpb.Error) {
+	isReverse := ba.IsReverse()
+
+	trace := tracer.FromCtx(ctx)
+
 	var br *roachpb.BatchResponse
+	// Stop retrying once the caller gives up on the batch, rather than
+	// blocking indefinitely on an unavailable range.
+	retryOptions := ds.rpcRetryOptions
+	retryOptions.Closer = ctx.Done()
 	// Send the request to one range per iteration.
 	for {
 		options := lookupOptions{
@@ -497,7 +625,7 @@ func (ds *DistSender) sendChunk(ctx context.Context, ba roachpb.BatchRequest) (*
 		var desc *roachpb.RangeDescriptor
 		var needAnother bool
 		var pErr *roachpb.Error
-		for r := retry.Start(ds.rpcRetryOptions); r.Next(); {
+		for r := retry.Start(retryOptions); r.Next(); {
 			// Get range descriptor (or, when spanning range, descriptors). Our
 			// error handling below may clear them on certain errors, so we
 			// refresh (likely from the cache) on every retry.
@@ -526,6 +654,15 @@ func (ds *DistSender) sendChunk(ctx context.Context, ba roachpb.BatchRequest) (*
 				return nil, roachpb.NewError(&roachpb.OpRequiresTxnError{})
 			}
 
+			// If the batch ends in an EndTransaction and spans ranges, the
+			// transaction can't commit in one round trip. Let the chunking
+			// sender send the EndTransaction separately, after the writes.
+			if needAnother && len(ba.Requests) > 1 {
+				if _, ok := ba.GetArg(roachpb.EndTransaction); ok {
+					return nil, errNo1PCTxn
+				}
+			}
+
 			// It's possible that the returned descriptor misses parts of the
 			// keys it's supposed to scan after it's truncated to match the
 			// descriptor. Example revscan [a,g), first desc lookup for "g"
@@ -587,10 +724,18 @@ func (ds *DistSender) sendChunk(ctx context.Context, ba roachpb.BatchRequest) (*
 				if tErr.CanRetry() {
 					continue
 				}
+				// None of the replicas, including the one cached as the
+				// leader, could be reached.
+				ds.updateLeaderCache(desc.RangeID, roachpb.ReplicaDescriptor{})
 			case *roachpb.RangeNotFoundError, *roachpb.RangeKeyMismatchError:
 				trace.Event(fmt.Sprintf("reply error: %T", tErr))
 				// Range descriptor might be out of date - evict it.
 				evictDesc()
+				if _, ok := tErr.(*roachpb.RangeNotFoundError); ok {
+					// The replica we believed to be the leader no longer
+					// has the range, so don't send there first again.
+					ds.updateLeaderCache(desc.RangeID, roachpb.ReplicaDescriptor{})
+				}
 				// On addressing errors, don't backoff; retry immediately.
 				r.Reset()
 				if log.V(1) {
@@ -640,8 +785,12 @@ func (ds *DistSender) sendChunk(ctx context.Context, ba roachpb.BatchRequest) (*
 			break
 		}
 
-		// Immediately return if querying a range failed non-retryably.
+		// Immediately return if querying a range failed non-retryably, or
+		// if the context expired while retrying.
 		if pErr != nil {
+			if err := ctx.Err(); err != nil {
+				return nil, roachpb.NewError(err)
+			}
 			return nil, pErr
 		}
 
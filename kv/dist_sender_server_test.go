@@ -215,6 +215,43 @@ func TestMultiRangeScanReverseScanDeleteResolve(t *testing.T) {
 	}
 }
 
+// TestMultiRangeScanInTxn verifies that a transactional scan spanning a
+// range boundary returns the rows from both ranges in order, including
+// the transaction's own uncommitted writes.
+func TestMultiRangeScanInTxn(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s, db := setupMultipleRanges(t, "m")
+	defer s.Stop()
+
+	for _, key := range []string{"a", "n"} {
+		if err := db.Put(key, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expKeys := []roachpb.Key{roachpb.Key("a"), roachpb.Key("l"), roachpb.Key("m"), roachpb.Key("n"), roachpb.Key("z")}
+	if err := db.Txn(func(txn *client.Txn) error {
+		for _, key := range []string{"z", "m", "l"} {
+			if err := txn.Put(key, "value"); err != nil {
+				return err
+			}
+		}
+		rows, err := txn.Scan("a", "zz", 0)
+		if err != nil {
+			return err
+		}
+		var keys []roachpb.Key
+		for _, row := range rows {
+			keys = append(keys, row.Key)
+		}
+		if !reflect.DeepEqual(keys, expKeys) {
+			t.Errorf("expected keys %v; got %v", expKeys, keys)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestMultiRangeScanReverseScanInconsistent verifies that a Scan/ReverseScan
 // across ranges that doesn't require read consistency will set a timestamp
 // using the clock local to the distributed sender.
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"net"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -36,7 +37,9 @@ import (
 	"github.com/cockroachdb/cockroach/testutils"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/leaktest"
+	"github.com/cockroachdb/cockroach/util/retry"
 	"github.com/gogo/protobuf/proto"
+	"golang.org/x/net/context"
 )
 
 var testRangeDescriptor = roachpb.RangeDescriptor{
@@ -444,6 +447,36 @@ func TestRetryOnDescriptorLookupError(t *testing.T) {
 	}
 }
 
+// TestRetryStopsOnContextDone verifies that the DistSender stops
+// retrying a range which is unavailable once the context of the batch
+// is done.
+func TestRetryStopsOnContextDone(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	g, s := makeTestGossip(t)
+	defer s()
+
+	var testFn rpcSendFn = func(_ rpc.Options, _ string, _ []net.Addr, getArgs func(addr net.Addr) proto.Message, _ func() proto.Message, _ *rpc.Context) ([]proto.Message, error) {
+		return nil, &roachpb.SendError{Message: "unavailable", Retryable: true}
+	}
+	ctx := &DistSenderContext{
+		RPCSend: testFn,
+		RPCRetryOptions: &retry.Options{
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		},
+		RangeDescriptorDB: mockRangeDescriptorDB(func(_ roachpb.Key, _ lookupOptions) ([]roachpb.RangeDescriptor, error) {
+			return []roachpb.RangeDescriptor{testRangeDescriptor}, nil
+		}),
+	}
+	ds := NewDistSender(ctx, g)
+	put := roachpb.NewPut(roachpb.Key("a"), roachpb.Value{Bytes: []byte("value")})
+	sendCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.SendWrapped(ds, sendCtx, put); !testutils.IsError(err, context.DeadlineExceeded.Error()) {
+		t.Errorf("expected the context to expire; got %v", err)
+	}
+}
+
 func TestEvictCacheOnError(t *testing.T) {
 	defer leaktest.AfterTest(t)
 	// if rpcError is true, the first attempt gets an RPC error, otherwise
@@ -498,7 +531,7 @@ func TestEvictCacheOnError(t *testing.T) {
 		if _, err := client.SendWrapped(ds, nil, put); err != nil && !testutils.IsError(err, "boom") {
 			t.Errorf("put encountered unexpected error: %s", err)
 		}
-		if cur := ds.leaderCache.Lookup(1); reflect.DeepEqual(cur, &roachpb.ReplicaDescriptor{}) && !tc.shouldClearLeader {
+		if cur := ds.leaderCache.Lookup(1); (cur.StoreID == 0) != tc.shouldClearLeader {
 			t.Errorf("%d: leader cache eviction: shouldClearLeader=%t, but value is %v", i, tc.shouldClearLeader, cur)
 		}
 		_, cachedDesc := ds.rangeCache.getCachedRangeDescriptor(put.Key, false /* !inclusive */)
@@ -508,6 +541,53 @@ func TestEvictCacheOnError(t *testing.T) {
 	}
 }
 
+// TestEvictLeaderOnRangeNotFound verifies that the cached leader of a
+// range is evicted when it turns out not to have the range any more,
+// and that the leader learned from a subsequent NotLeaderError is
+// cached instead.
+func TestEvictLeaderOnRangeNotFound(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	g, s := makeTestGossip(t)
+	defer s()
+	staleLeader := roachpb.ReplicaDescriptor{NodeID: 99, StoreID: 999}
+	newLeader := roachpb.ReplicaDescriptor{NodeID: 1, StoreID: 1}
+
+	var attempts int
+	var testFn rpcSendFn = func(_ rpc.Options, _ string, _ []net.Addr, getArgs func(addr net.Addr) proto.Message, getReply func() proto.Message, _ *rpc.Context) ([]proto.Message, error) {
+		attempts++
+		reply := getReply().(*roachpb.BatchResponse)
+		switch attempts {
+		case 1:
+			reply.SetGoError(&roachpb.RangeNotFoundError{RangeID: 1})
+		case 2:
+			reply.SetGoError(&roachpb.NotLeaderError{Leader: &newLeader, Replica: &roachpb.ReplicaDescriptor{}})
+		default:
+			return []proto.Message{getArgs(nil).(*roachpb.BatchRequest).CreateReply()}, nil
+		}
+		return []proto.Message{reply}, nil
+	}
+
+	ctx := &DistSenderContext{
+		RPCSend: testFn,
+		RangeDescriptorDB: mockRangeDescriptorDB(func(_ roachpb.Key, _ lookupOptions) ([]roachpb.RangeDescriptor, error) {
+			return []roachpb.RangeDescriptor{testRangeDescriptor}, nil
+		}),
+	}
+	ds := NewDistSender(ctx, g)
+	ds.updateLeaderCache(1, staleLeader)
+
+	put := roachpb.NewPut(roachpb.Key("a"), roachpb.Value{Bytes: []byte("value")})
+	if _, err := client.SendWrapped(ds, nil, put); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts; got %d", attempts)
+	}
+	if cur := ds.leaderCache.Lookup(1); cur.StoreID != newLeader.StoreID {
+		t.Errorf("expected cached leader %v; got %v", newLeader, cur)
+	}
+}
+
 // TestRetryOnWrongReplicaError sets up a DistSender on a minimal gossip
 // network and a mock of rpc.Send, and verifies that the DistSender correctly
 // retries upon encountering a stale entry in its range descriptor cache.
@@ -764,6 +844,95 @@ func TestMultiRangeMergeStaleDescriptor(t *testing.T) {
 	}
 }
 
+// TestMultiRangeParallelScan verifies that unbounded scans spanning
+// several ranges are sent to all of them at once, and that the results
+// are recombined in key order.
+func TestMultiRangeParallelScan(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	g, s := makeTestGossip(t)
+	defer s()
+	replicas := []roachpb.ReplicaDescriptor{{NodeID: 1, StoreID: 1}}
+	descs := []roachpb.RangeDescriptor{
+		{RangeID: 1, StartKey: roachpb.Key("a"), EndKey: roachpb.Key("b"), Replicas: replicas},
+		{RangeID: 2, StartKey: roachpb.Key("b"), EndKey: roachpb.KeyMax, Replicas: replicas},
+	}
+	existingKVs := []roachpb.KeyValue{
+		{Key: roachpb.Key("a"), Value: roachpb.Value{Bytes: []byte("1")}},
+		{Key: roachpb.Key("c"), Value: roachpb.Value{Bytes: []byte("2")}},
+	}
+
+	// Each range only replies once the other one has been sent its part
+	// of the batch as well.
+	var arrived sync.WaitGroup
+	var allArrived chan struct{}
+	var testFn rpcSendFn = func(_ rpc.Options, method string, addrs []net.Addr, getArgs func(addr net.Addr) proto.Message, _ func() proto.Message, _ *rpc.Context) ([]proto.Message, error) {
+		ba := getArgs(testAddress).(*roachpb.BatchRequest)
+		arrived.Done()
+		select {
+		case <-allArrived:
+		case <-time.After(5 * time.Second):
+			return nil, util.Errorf("ranges were not sent to in parallel")
+		}
+		var rows []roachpb.KeyValue
+		for _, kv := range existingKVs {
+			if !kv.Key.Less(ba.Key) && kv.Key.Less(ba.EndKey) {
+				rows = append(rows, kv)
+			}
+		}
+		br := ba.CreateReply()
+		switch reply := br.Responses[0].GetInner().(type) {
+		case *roachpb.ScanResponse:
+			reply.Rows = rows
+		case *roachpb.ReverseScanResponse:
+			for i := len(rows) - 1; i >= 0; i-- {
+				reply.Rows = append(reply.Rows, rows[i])
+			}
+		}
+		return []proto.Message{br}, nil
+	}
+	ctx := &DistSenderContext{
+		RPCSend: testFn,
+		RangeDescriptorDB: mockRangeDescriptorDB(func(_ roachpb.Key, _ lookupOptions) ([]roachpb.RangeDescriptor, error) {
+			return descs, nil
+		}),
+	}
+	ds := NewDistSender(ctx, g)
+
+	testCases := []struct {
+		args    roachpb.Request
+		expRows []roachpb.KeyValue
+	}{
+		{roachpb.NewScan(roachpb.Key("a"), roachpb.Key("d"), 0), existingKVs},
+		{roachpb.NewReverseScan(roachpb.Key("a"), roachpb.Key("d"), 0),
+			[]roachpb.KeyValue{existingKVs[1], existingKVs[0]}},
+	}
+	for i, test := range testCases {
+		arrived.Add(len(descs))
+		allArrived = make(chan struct{})
+		go func(allArrived chan struct{}) {
+			arrived.Wait()
+			close(allArrived)
+		}(allArrived)
+
+		// Set the Txn info to avoid an OpRequiresTxnError.
+		test.args.Header().Txn = &roachpb.Transaction{}
+		reply, err := client.SendWrapped(ds, nil, test.args)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		var rows []roachpb.KeyValue
+		switch r := reply.(type) {
+		case *roachpb.ScanResponse:
+			rows = r.Rows
+		case *roachpb.ReverseScanResponse:
+			rows = r.Rows
+		}
+		if !reflect.DeepEqual(test.expRows, rows) {
+			t.Errorf("%d: expected %v, got %v", i, test.expRows, rows)
+		}
+	}
+}
+
 // TestRangeLookupOptionOnReverseScan verifies that a lookup triggered by a
 // ReverseScan request has the `useReverseScan` option specified.
 func TestRangeLookupOptionOnReverseScan(t *testing.T) {
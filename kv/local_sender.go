@@ -38,6 +38,7 @@ type LocalSender struct {
 }
 
 var _ client.Sender = &LocalSender{}
+var _ client.Watcher = &LocalSender{}
 var _ rangeDescriptorDB = &LocalSender{}
 
 // NewLocalSender returns a local-only sender which directly accesses
@@ -156,6 +157,50 @@ func (ls *LocalSender) Send(ctx context.Context, ba roachpb.BatchRequest) (*roac
 	return br, pErr
 }
 
+// Watch implements the client.Watcher interface, merging the updates
+// committed by the replicas of all stores. The returned channel is
+// closed as soon as the watch on any one of the stores ends.
+func (ls *LocalSender) Watch(ctx context.Context, start, end roachpb.Key) (<-chan client.WatchEvent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var chans []<-chan client.WatchEvent
+	if err := ls.VisitStores(func(s *storage.Store) error {
+		c, err := s.Watch(ctx, start, end)
+		if err != nil {
+			return err
+		}
+		chans = append(chans, c)
+		return nil
+	}); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	out := make(chan client.WatchEvent)
+	var wg sync.WaitGroup
+	for _, c := range chans {
+		wg.Add(1)
+		go func(c <-chan client.WatchEvent) {
+			defer wg.Done()
+			// If this store dropped the watcher, end the watch on the
+			// others too so that the receiver sees the channel closed.
+			defer cancel()
+			for e := range c {
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(c)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(out)
+	}()
+	return out, nil
+}
+
 // lookupReplica looks up replica by key [range]. Lookups are done
 // by consulting each store in turn via Store.LookupRange(key).
 // Returns RangeID and replica on success; RangeKeyMismatch error
@@ -21,6 +21,7 @@ import (
 	"bytes"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/biogo/store/llrb"
 	"github.com/cockroachdb/cockroach/keys"
@@ -68,6 +69,24 @@ type rangeDescriptorCache struct {
 	rangeCache *cache.OrderedCache
 	// rangeCacheMu protects rangeCache for concurrent access
 	rangeCacheMu sync.RWMutex
+	// hits, misses and evictions count cache lookups and invalidations;
+	// they are only accessed atomically. See RangeDescriptorCacheMetrics.
+	hits, misses, evictions int64
+}
+
+// RangeDescriptorCacheMetrics holds the counters of a range descriptor
+// cache at the time they were retrieved.
+type RangeDescriptorCacheMetrics struct {
+	// Hits and Misses are the number of lookups which were answered from
+	// the cache and which had to query the range metadata, respectively.
+	// Lookups of the meta ranges themselves are included.
+	Hits, Misses int64
+	// Evictions is the number of descriptors evicted after being found to
+	// be stale. Descriptors evicted to make room for new ones, or replaced
+	// by overlapping ones, are not counted.
+	Evictions int64
+	// Entries is the number of descriptors currently cached.
+	Entries int64
 }
 
 // newRangeDescriptorCache returns a new RangeDescriptorCache which
@@ -85,6 +104,19 @@ func newRangeDescriptorCache(db rangeDescriptorDB, size int) *rangeDescriptorCac
 	}
 }
 
+// metrics returns a snapshot of the cache's counters.
+func (rdc *rangeDescriptorCache) metrics() RangeDescriptorCacheMetrics {
+	rdc.rangeCacheMu.RLock()
+	entries := rdc.rangeCache.Len()
+	rdc.rangeCacheMu.RUnlock()
+	return RangeDescriptorCacheMetrics{
+		Hits:      atomic.LoadInt64(&rdc.hits),
+		Misses:    atomic.LoadInt64(&rdc.misses),
+		Evictions: atomic.LoadInt64(&rdc.evictions),
+		Entries:   int64(entries),
+	}
+}
+
 func (rdc *rangeDescriptorCache) String() string {
 	rdc.rangeCacheMu.RLock()
 	defer rdc.rangeCacheMu.RUnlock()
@@ -115,8 +147,10 @@ func (rdc *rangeDescriptorCache) stringLocked() string {
 func (rdc *rangeDescriptorCache) LookupRangeDescriptor(key roachpb.Key,
 	options lookupOptions) (*roachpb.RangeDescriptor, error) {
 	if _, r := rdc.getCachedRangeDescriptor(key, options.useReverseScan); r != nil {
+		atomic.AddInt64(&rdc.hits, 1)
 		return r, nil
 	}
+	atomic.AddInt64(&rdc.misses, 1)
 
 	if log.V(2) {
 		log.Infof("lookup range descriptor: key=%s\n%s", key, rdc)
@@ -223,7 +257,10 @@ func (rdc *rangeDescriptorCache) EvictCachedRangeDescriptor(descKey roachpb.Key,
 		} else if log.V(1) {
 			log.Infof("evict cached descriptor: key=%s desc=%s", descKey, cachedDesc)
 		}
-		rdc.rangeCache.Del(rngKey)
+		if cachedDesc != nil {
+			rdc.rangeCache.Del(rngKey)
+			atomic.AddInt64(&rdc.evictions, 1)
+		}
 
 		// Retrieve the metadata range key for the next level of metadata, and
 		// evict that key as well. This loop ends after the meta1 range, which
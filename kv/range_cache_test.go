@@ -218,6 +218,76 @@ func TestRangeCache(t *testing.T) {
 
 }
 
+// TestRangeCacheEvictAfterSplit verifies that a descriptor which became
+// stale due to a split keeps being served from the cache until it is
+// evicted, after which the descriptors of both halves are looked up and
+// cached.
+func TestRangeCacheEvictAfterSplit(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	db := newTestDescriptorDB()
+	db.cache = newRangeDescriptorCache(db, 2<<10)
+
+	stale := doLookup(t, db.cache, "b")
+	db.assertLookupCount(t, 2, "b")
+
+	db.splitRange(t, roachpb.Key("m"))
+
+	// The cache doesn't know about the split yet.
+	if desc := doLookup(t, db.cache, "b"); desc != stale {
+		t.Fatalf("expected cached descriptor %+v; got %+v", stale, desc)
+	}
+	db.assertLookupCount(t, 0, "b")
+
+	// After eviction, the left half is looked up and the right half is
+	// cached along with it.
+	db.cache.EvictCachedRangeDescriptor(roachpb.Key("b"), stale, false)
+	if desc := doLookup(t, db.cache, "b"); !desc.EndKey.Equal(roachpb.Key("m")) {
+		t.Errorf("expected descriptor ending at \"m\"; got %+v", desc)
+	}
+	if db.lookupCount == 0 {
+		t.Error("expected a lookup after eviction")
+	}
+	db.lookupCount = 0
+	if desc := doLookup(t, db.cache, "x"); !desc.StartKey.Equal(roachpb.Key("m")) {
+		t.Errorf("expected descriptor starting at \"m\"; got %+v", desc)
+	}
+	db.assertLookupCount(t, 0, "x")
+}
+
+// TestRangeCacheMetrics verifies that cache hits, misses and
+// evictions of stale descriptors are counted.
+func TestRangeCacheMetrics(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	db := newTestDescriptorDB()
+	db.cache = newRangeDescriptorCache(db, 2<<10)
+
+	// The first lookup misses on both the key and its meta2 key.
+	stale := doLookup(t, db.cache, "b")
+	if m := db.cache.metrics(); m.Hits != 0 || m.Misses != 2 || m.Entries == 0 {
+		t.Errorf("unexpected metrics after first lookup: %+v", m)
+	}
+	doLookup(t, db.cache, "c")
+	if m := db.cache.metrics(); m.Hits != 1 || m.Misses != 2 {
+		t.Errorf("unexpected metrics after cached lookup: %+v", m)
+	}
+
+	db.splitRange(t, roachpb.Key("m"))
+	db.cache.EvictCachedRangeDescriptor(roachpb.Key("b"), stale, false)
+	m := db.cache.metrics()
+	if m.Evictions == 0 {
+		t.Errorf("expected evictions to be counted: %+v", m)
+	}
+	// Evicting again with the same stale descriptor is a no-op.
+	db.cache.EvictCachedRangeDescriptor(roachpb.Key("b"), stale, false)
+	if m2 := db.cache.metrics(); m2.Evictions != m.Evictions {
+		t.Errorf("expected no further evictions; got %+v", m2)
+	}
+	doLookup(t, db.cache, "b")
+	if m2 := db.cache.metrics(); m2.Misses <= m.Misses {
+		t.Errorf("expected a miss after eviction; got %+v", m2)
+	}
+}
+
 // TestRangeCacheClearOverlapping verifies that existing, overlapping
 // cached entries are cleared when adding a new entry.
 func TestRangeCacheClearOverlapping(t *testing.T) {
@@ -184,6 +184,7 @@ type TxnCoordSender struct {
 }
 
 var _ client.Sender = &TxnCoordSender{}
+var _ client.Watcher = &TxnCoordSender{}
 
 // NewTxnCoordSender creates a new TxnCoordSender for use from a KV
 // distributed DB instance.
@@ -304,6 +305,16 @@ func updateForBatch(args roachpb.Request, bHeader roachpb.BatchRequest_Header) e
 	return nil
 }
 
+// Watch implements the client.Watcher interface by passing the watch
+// through to the wrapped sender, if it supports watches.
+func (tc *TxnCoordSender) Watch(ctx context.Context, start, end roachpb.Key) (<-chan client.WatchEvent, error) {
+	w, ok := tc.wrapped.(client.Watcher)
+	if !ok {
+		return nil, util.Errorf("%T does not support watches", tc.wrapped)
+	}
+	return w.Watch(ctx, start, end)
+}
+
 // Send implements the batch.Sender interface. If the request is part of a
 // transaction, the TxnCoordSender adds the transaction to a map of active
 // transactions and begins heartbeating it. Every subsequent request for the
@@ -468,7 +479,8 @@ func (tc *TxnCoordSender) Send(ctx context.Context, ba roachpb.BatchRequest) (*r
 // maybeBeginTxn begins a new transaction if a txn has been specified
 // in the request but has a nil ID. The new transaction is initialized
 // using the name and isolation in the otherwise uninitialized txn.
-// The Priority, if non-zero is used as a minimum.
+// The Priority, if non-zero is used as a minimum. The Key, if set, is
+// used as the transaction's anchor instead of the first request's key.
 func (tc *TxnCoordSender) maybeBeginTxn(ba *roachpb.BatchRequest) {
 	if ba.Txn == nil {
 		return
@@ -477,15 +489,26 @@ func (tc *TxnCoordSender) maybeBeginTxn(ba *roachpb.BatchRequest) {
 		panic("empty batch with txn")
 	}
 	if len(ba.Txn.ID) == 0 {
-		// TODO(tschottdorf): should really choose the first txn write here.
-		firstKey := ba.Requests[0].GetInner().Header().Key
-		newTxn := roachpb.NewTransaction(ba.Txn.Name, keys.KeyAddress(firstKey), ba.GetUserPriority(),
+		anchorKey := ba.Txn.Key
+		if len(anchorKey) == 0 {
+			// TODO(tschottdorf): should really choose the first txn write here.
+			anchorKey = ba.Requests[0].GetInner().Header().Key
+		}
+		newTxn := roachpb.NewTransaction(ba.Txn.Name, keys.KeyAddress(anchorKey), ba.GetUserPriority(),
 			ba.Txn.Isolation, tc.clock.Now(), tc.clock.MaxOffset().Nanoseconds())
 		// Use existing priority as a minimum. This is used on transaction
 		// aborts to ratchet priority when creating successor transaction.
 		if newTxn.Priority < ba.Txn.Priority {
 			newTxn.Priority = ba.Txn.Priority
 		}
+		// A timestamp supplied by the client fixes the timestamp of a
+		// read-only transaction reading historical data. The values
+		// visible at it are known, so there is no uncertainty interval.
+		if ts := ba.Txn.OrigTimestamp; ts != roachpb.ZeroTimestamp {
+			newTxn.Timestamp = ts
+			newTxn.OrigTimestamp = ts
+			newTxn.MaxTimestamp = ts
+		}
 		ba.Txn = newTxn
 	}
 }
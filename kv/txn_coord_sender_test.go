@@ -211,6 +211,35 @@ func TestTxnCoordSenderBeginTransactionMinPriority(t *testing.T) {
 	}
 }
 
+// TestTxnCoordSenderBeginTransactionFixedTimestamp verifies that a
+// timestamp supplied when starting a new transaction is used as the
+// transaction's timestamp, without an uncertainty interval.
+func TestTxnCoordSenderBeginTransactionFixedTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := createTestDB(t)
+	defer s.Stop()
+	defer teardownHeartbeats(s.Sender)
+
+	s.Manual.Set(100)
+	ts := roachpb.Timestamp{WallTime: 10}
+	reply, err := client.SendWrapped(s.Sender, nil, &roachpb.GetRequest{
+		RequestHeader: roachpb.RequestHeader{
+			Key: roachpb.Key("key"),
+			Txn: &roachpb.Transaction{
+				Name:          "test txn",
+				OrigTimestamp: ts,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := reply.(*roachpb.GetResponse).Txn
+	if !txn.Timestamp.Equal(ts) || !txn.OrigTimestamp.Equal(ts) || !txn.MaxTimestamp.Equal(ts) {
+		t.Errorf("expected txn timestamps to be fixed at %s; got %s", ts, txn)
+	}
+}
+
 // TestTxnCoordSenderKeyRanges verifies that multiple requests to same or
 // overlapping key ranges causes the coordinator to keep track only of
 // the minimum number of ranges.
@@ -258,6 +287,57 @@ func TestTxnCoordSenderKeyRanges(t *testing.T) {
 	}
 }
 
+// TestTxnCoordSenderEndTxnIntentsAcrossEpochs verifies that the intents
+// sent with EndTransaction cover each key written by the transaction
+// exactly once, including keys written only in an earlier epoch.
+func TestTxnCoordSenderEndTxnIntentsAcrossEpochs(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	stopper := stop.NewStopper()
+	defer stopper.Stop()
+	manual := hlc.NewManualClock(0)
+	clock := hlc.NewClock(manual.UnixNano)
+
+	var intents []roachpb.Intent
+	ts := NewTxnCoordSender(senderFn(func(_ context.Context, ba roachpb.BatchRequest) (*roachpb.BatchResponse, *roachpb.Error) {
+		if args, ok := ba.GetArg(roachpb.Put); ok && ba.Txn.Epoch == 0 && args.Header().Key.Equal(roachpb.Key("c")) {
+			return nil, roachpb.NewError(roachpb.NewTransactionRetryError(ba.Txn))
+		}
+		br := ba.CreateReply()
+		br.Txn = ba.Txn.Clone()
+		if args, ok := ba.GetArg(roachpb.EndTransaction); ok {
+			intents = args.(*roachpb.EndTransactionRequest).Intents
+			br.Txn.Status = roachpb.COMMITTED
+		}
+		return br, nil
+	}), clock, false, nil, stopper)
+
+	db := client.NewDB(ts)
+	if err := db.Txn(func(txn *client.Txn) error {
+		keys := []string{"b", "c", "d"}
+		if txn.Proto.Epoch == 0 {
+			keys = []string{"a", "b", "c"}
+		}
+		for _, key := range keys {
+			if err := txn.Put(key, "value"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expIntents := []roachpb.Intent{
+		{Key: roachpb.Key("a")},
+		{Key: roachpb.Key("b")},
+		{Key: roachpb.Key("c")},
+		{Key: roachpb.Key("d")},
+	}
+	if !reflect.DeepEqual(intents, expIntents) {
+		t.Errorf("expected intents %v; got %v", expIntents, intents)
+	}
+}
+
 // TestTxnCoordSenderMultipleTxns verifies correct operation with
 // multiple outstanding transactions.
 func TestTxnCoordSenderMultipleTxns(t *testing.T) {
@@ -32,6 +32,7 @@ import (
 	"github.com/cockroachdb/cockroach/roachpb"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/testutils"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/hlc"
 	"github.com/cockroachdb/cockroach/util/leaktest"
@@ -635,3 +636,320 @@ func TestTxnRestartedSerializableTimestampRegression(t *testing.T) {
 	// Wait for txnA to finish.
 	<-ch
 }
+
+// TestTxnReadTimestamp verifies that a transaction with a fixed read
+// timestamp sees the values as of that timestamp and can't write.
+func TestTxnReadTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := createTestDB(t)
+	defer s.Stop()
+
+	key := roachpb.Key("key")
+	var timestamps []roachpb.Timestamp
+	for i := 0; i < 2; i++ {
+		s.Manual.Increment(1)
+		ts := s.Clock.Now()
+		value := roachpb.Value{Bytes: []byte(fmt.Sprintf("value-%d", i))}
+		if err := engine.MVCCPut(s.Eng, nil, key, ts, value, nil); err != nil {
+			t.Fatal(err)
+		}
+		timestamps = append(timestamps, ts)
+	}
+	s.Manual.Increment(1)
+
+	for i, ts := range timestamps {
+		expected := []byte(fmt.Sprintf("value-%d", i))
+		if err := s.DB.TxnWithOptions(client.TxnOptions{ReadTimestamp: ts}, func(txn *client.Txn) error {
+			gr, err := txn.Get(key)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(gr.ValueBytes(), expected) {
+				t.Errorf("%d: expected %q at %s; got %q", i, expected, ts, gr.ValueBytes())
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := s.DB.TxnWithOptions(client.TxnOptions{ReadTimestamp: timestamps[0]}, func(txn *client.Txn) error {
+		return txn.Put(key, "value")
+	})
+	if !testutils.IsError(err, "not permitted in transaction reading at fixed timestamp") {
+		t.Errorf("expected write to be rejected; got %v", err)
+	}
+}
+
+// TestTxnDelRangeMax verifies that a transactional range deletion
+// reports the number of rows deleted, honors the maximum and is visible
+// both within the transaction and after it commits.
+func TestTxnDelRangeMax(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := createTestDB(t)
+	defer s.Stop()
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := s.DB.Put(key, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expKeys := []roachpb.Key{roachpb.Key("d")}
+	checkKeys := func(rows []client.KeyValue) {
+		var keys []roachpb.Key
+		for _, row := range rows {
+			keys = append(keys, row.Key)
+		}
+		if !reflect.DeepEqual(keys, expKeys) {
+			t.Errorf("expected keys %v; got %v", expKeys, keys)
+		}
+	}
+
+	if err := s.DB.Txn(func(txn *client.Txn) error {
+		testCases := []struct {
+			start, end string
+			max        int64
+			expDeleted int64
+		}{
+			{"a", "c", 0, 2},
+			{"x", "y", 0, 0},
+			{"c", "z", 1, 1},
+		}
+		for i, test := range testCases {
+			n, err := txn.DelRangeMax(test.start, test.end, test.max)
+			if err != nil {
+				return err
+			}
+			if n != test.expDeleted {
+				t.Errorf("%d: expected %d rows deleted; got %d", i, test.expDeleted, n)
+			}
+		}
+		rows, err := txn.Scan("a", "z", 0)
+		if err != nil {
+			return err
+		}
+		checkKeys(rows)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := s.DB.Scan("a", "z", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(rows)
+}
+
+// TestTxnCommitTimestamp verifies that the commit timestamp returned for
+// a transaction reflects a push of its timestamp and matches the
+// timestamp of the values it wrote.
+func TestTxnCommitTimestamp(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := createTestDB(t)
+	defer s.Stop()
+
+	key := roachpb.Key("a")
+	var origTS roachpb.Timestamp
+	commitTS, err := s.DB.TxnReturningTimestamp(func(txn *client.Txn) error {
+		// Use snapshot isolation so that the push doesn't cause a retry.
+		if err := txn.SetIsolation(roachpb.SNAPSHOT); err != nil {
+			return err
+		}
+		if err := txn.Put("b", "value"); err != nil {
+			return err
+		}
+		origTS = txn.Proto.OrigTimestamp
+		// Read the key at a later timestamp, which pushes the
+		// transaction's write to it.
+		s.Manual.Increment(100)
+		if _, err := s.DB.Get(key); err != nil {
+			return err
+		}
+		return txn.Put(key, "value")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !origTS.Less(commitTS) {
+		t.Errorf("expected commit timestamp %s to be pushed past %s", commitTS, origTS)
+	}
+	for _, k := range []interface{}{key, "b"} {
+		kv, err := s.DB.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts := *kv.Value.Timestamp; !ts.Equal(commitTS) {
+			t.Errorf("%s: expected value written at commit timestamp %s; got %s", k, commitTS, ts)
+		}
+	}
+}
+
+// TestTxnSerializablePushRestarts verifies that a SERIALIZABLE
+// transaction whose timestamp is pushed is restarted, running its
+// function again in a new epoch.
+func TestTxnSerializablePushRestarts(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := createTestDB(t)
+	defer s.Stop()
+
+	key := roachpb.Key("a")
+	var epochs []int32
+	if err := s.DB.Txn(func(txn *client.Txn) error {
+		epochs = append(epochs, txn.Proto.Epoch)
+		if err := txn.Put("b", "value"); err != nil {
+			return err
+		}
+		if len(epochs) == 1 {
+			// Read the key at a later timestamp, which pushes the
+			// transaction's write to it.
+			s.Manual.Increment(100)
+			if _, err := s.DB.Get(key); err != nil {
+				return err
+			}
+		}
+		return txn.Put(key, "value")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if expEpochs := []int32{0, 1}; !reflect.DeepEqual(epochs, expEpochs) {
+		t.Errorf("expected attempts in epochs %v; got %v", expEpochs, epochs)
+	}
+}
+
+// TestTxnInconsistentReads verifies that inconsistent reads of a key
+// with an outstanding intent don't block but return the previously
+// committed value, both inside and outside of the writing transaction,
+// whereas a consistent read in the transaction sees its own write.
+func TestTxnInconsistentReads(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := createTestDB(t)
+	defer s.Stop()
+
+	key := roachpb.Key("a")
+	if err := s.DB.Put(key, "old"); err != nil {
+		t.Fatal(err)
+	}
+	inconsistentGet := func(r client.Runner) (string, error) {
+		b := &client.Batch{ReadConsistency: roachpb.INCONSISTENT}
+		b.Get(key)
+		b.Scan(key, key.Next(), 0)
+		if err := r.Run(b); err != nil {
+			return "", err
+		}
+		get, scan := b.Results[0].Rows[0], b.Results[1].Rows
+		if len(scan) != 1 || !bytes.Equal(get.ValueBytes(), scan[0].ValueBytes()) {
+			return "", util.Errorf("expected get and scan to agree; got %s and %v", get, scan)
+		}
+		return string(get.ValueBytes()), nil
+	}
+
+	if err := s.DB.Txn(func(txn *client.Txn) error {
+		if err := txn.Put(key, "new"); err != nil {
+			return err
+		}
+		kv, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		if v := string(kv.ValueBytes()); v != "new" {
+			t.Errorf("expected consistent read to see own write; got %q", v)
+		}
+		for i, r := range []client.Runner{txn, s.DB} {
+			v, err := inconsistentGet(r)
+			if err != nil {
+				return err
+			}
+			if v != "old" {
+				t.Errorf("%d: expected inconsistent read to return committed value; got %q", i, v)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTxnAnchorKey verifies that a transaction's record is anchored at
+// the key given in the transaction options, and at the key of its first
+// request otherwise.
+func TestTxnAnchorKey(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := createTestDB(t)
+	defer s.Stop()
+
+	testCases := []struct {
+		anchorKey, expKey roachpb.Key
+	}{
+		{nil, roachpb.Key("a")},
+		{roachpb.Key("z"), roachpb.Key("z")},
+	}
+	for i, test := range testCases {
+		if err := s.DB.TxnWithOptions(client.TxnOptions{AnchorKey: test.anchorKey}, func(txn *client.Txn) error {
+			if err := txn.Put("a", "value"); err != nil {
+				return err
+			}
+			if !txn.Proto.Key.Equal(test.expKey) {
+				t.Errorf("%d: expected txn anchored at %s; got %s", i, test.expKey, txn.Proto.Key)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+	}
+}
+
+// TestTxnOnePhaseCommit verifies that a transaction whose writes and
+// commit are sent in one batch is committed in a single round trip when
+// all of its writes go to the range holding its record, which leaves no
+// transaction record behind. When the writes span ranges, the commit is
+// sent separately and the record is kept.
+func TestTxnOnePhaseCommit(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	s := createTestDB(t)
+	defer s.Stop()
+
+	if err := s.DB.AdminSplit("m"); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		keys      []string
+		expRecord bool
+	}{
+		{[]string{"a", "b"}, false},
+		{[]string{"c", "x"}, true},
+	}
+	for i, test := range testCases {
+		var txnKey roachpb.Key
+		if err := s.DB.Txn(func(txn *client.Txn) error {
+			b := &client.Batch{}
+			for _, key := range test.keys {
+				b.Put(key, "value")
+			}
+			if err := txn.CommitInBatch(b); err != nil {
+				return err
+			}
+			txnKey = keys.TransactionKey(txn.Proto.Key, txn.Proto.ID)
+			return nil
+		}); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		for _, key := range test.keys {
+			if gr, err := s.DB.Get(key); err != nil {
+				t.Fatalf("%d: %s", i, err)
+			} else if !gr.Exists() {
+				t.Errorf("%d: expected %q to be written", i, key)
+			}
+		}
+		var txnRecord roachpb.Transaction
+		ok, err := engine.MVCCGetProto(s.Eng, txnKey, roachpb.ZeroTimestamp, true, nil, &txnRecord)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if ok != test.expRecord {
+			t.Errorf("%d: expected transaction record to exist: %t, got %t", i, test.expRecord, ok)
+		}
+	}
+}
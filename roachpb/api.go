@@ -78,6 +78,12 @@ func IsTransactionWrite(args Request) bool {
 	return (args.flags() & isTxnWrite) != 0
 }
 
+// IsTransactional returns true if the request may be sent as part of a
+// transaction. Admin commands are not run transactionally.
+func IsTransactional(args Request) bool {
+	return (args.flags() & isAdmin) == 0
+}
+
 // IsRange returns true if the operation is range-based and must include
 // a start and an end key.
 func IsRange(args Request) bool {
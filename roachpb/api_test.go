@@ -229,15 +229,24 @@ func TestBatchSplit(t *testing.T) {
 	dr := &DeleteRangeRequest{}
 	et := &EndTransactionRequest{}
 	rv := &ReverseScanRequest{}
+	etTrigger := &EndTransactionRequest{InternalCommitTrigger: &InternalCommitTrigger{}}
 	testCases := []struct {
-		reqs  []Request
-		sizes []int
+		reqs       []Request
+		sizes      []int
+		canSplitET bool
 	}{
-		{[]Request{get, put}, []int{1, 1}},
-		{[]Request{get, get, get, put, put, get, get}, []int{3, 2, 2}},
-		{[]Request{get, scan, get, dr, rv, put, et}, []int{3, 1, 1, 1, 1}},
-		{[]Request{spl, get, scan, spl, get}, []int{1, 2, 1, 1}},
-		{[]Request{spl, spl, get, spl}, []int{1, 1, 1, 1}},
+		{[]Request{get, put}, []int{1, 1}, true},
+		{[]Request{get, get, get, put, put, get, get}, []int{3, 2, 2}, true},
+		{[]Request{get, scan, get, dr, rv, put, et}, []int{3, 1, 1, 1, 1}, true},
+		{[]Request{spl, get, scan, spl, get}, []int{1, 2, 1, 1}, true},
+		{[]Request{spl, spl, get, spl}, []int{1, 1, 1, 1}, true},
+		// An EndTransaction stays with the writes preceding it unless it
+		// may be split off or carries a commit trigger.
+		{[]Request{get, scan, get, dr, rv, put, et}, []int{3, 1, 1, 2}, false},
+		{[]Request{put, dr, et}, []int{3}, false},
+		{[]Request{get, et}, []int{1, 1}, false},
+		{[]Request{et}, []int{1}, false},
+		{[]Request{put, etTrigger}, []int{1, 1}, false},
 	}
 
 	for i, test := range testCases {
@@ -247,7 +256,7 @@ func TestBatchSplit(t *testing.T) {
 		}
 		var partLen []int
 		var recombined []RequestUnion
-		for _, part := range ba.Split() {
+		for _, part := range ba.Split(test.canSplitET) {
 			recombined = append(recombined, part...)
 			partLen = append(partLen, len(part))
 		}
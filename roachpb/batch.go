@@ -201,16 +201,21 @@ func (ba *BatchRequest) flags() int {
 
 // Split separate the requests contained in a batch so that each subset of
 // requests can be executed by a Store (without changing order). In particular,
-// Admin and EndTransaction requests are always singled out and mutating
-// requests separated from reads.
-func (ba BatchRequest) Split() [][]RequestUnion {
-	compatible := func(exFlags, newFlags int) bool {
+// Admin requests are always singled out and mutating requests separated from
+// reads. Unless canSplitET is false, so are EndTransaction requests. If it is
+// false, an EndTransaction without a commit trigger stays with the writes
+// preceding it, which allows a transaction whose writes all go to a single
+// range to commit in one round trip.
+func (ba BatchRequest) Split(canSplitET bool) [][]RequestUnion {
+	compatible := func(args Request, exFlags, newFlags int) bool {
 		// If no flags are set so far, everything goes.
 		if exFlags == 0 {
 			return true
 		}
 		if (newFlags & isAlone) != 0 {
-			return false
+			et, ok := args.(*EndTransactionRequest)
+			return ok && !canSplitET && et.InternalCommitTrigger == nil &&
+				(exFlags&(isWrite|isAdmin|isAlone)) == isWrite
 		}
 		// Otherwise, the flags below must remain the same
 		// with the new request added.
@@ -226,8 +231,9 @@ func (ba BatchRequest) Split() [][]RequestUnion {
 		part := ba.Requests
 		var gFlags int
 		for i, union := range ba.Requests {
-			flags := union.GetInner().flags()
-			if !compatible(gFlags, flags) {
+			args := union.GetInner()
+			flags := args.flags()
+			if !compatible(args, gFlags, flags) {
 				part = ba.Requests[:i]
 				break
 			}
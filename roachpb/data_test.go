@@ -303,6 +303,20 @@ func TestTxnIDEqual(t *testing.T) {
 	}
 }
 
+// TestMakePriority verifies that a zero user priority is treated as the
+// default user priority of 1 and that a negative one yields an explicit
+// priority.
+func TestMakePriority(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if p := MakePriority(0); p <= 0 {
+			t.Fatalf("expected positive priority for default user priority; got %d", p)
+		}
+	}
+	if p := MakePriority(-10); p != 10 {
+		t.Errorf("expected explicit priority 10; got %d", p)
+	}
+}
+
 func TestTransactionString(t *testing.T) {
 	id := []byte("ת\x0f^\xe4-Fؽ\xf7\x16\xe4\xf9\xbe^\xbe")
 	ts1 := makeTS(10, 11)
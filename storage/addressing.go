@@ -19,6 +19,7 @@ package storage
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/keys"
@@ -26,41 +27,245 @@ import (
 	"github.com/cockroachdb/cockroach/util"
 )
 
-type metaAction func(*client.Batch, roachpb.Key, *roachpb.RangeDescriptor)
+type metaAction func(*addressingPlan, roachpb.Key, *roachpb.RangeDescriptor)
 
-func putMeta(b *client.Batch, key roachpb.Key, desc *roachpb.RangeDescriptor) {
-	b.Put(key, desc)
+func putMeta(p *addressingPlan, key roachpb.Key, desc *roachpb.RangeDescriptor) {
+	*p = append(*p, AddressingOp{Key: key, Desc: desc})
 }
 
-func delMeta(b *client.Batch, key roachpb.Key, desc *roachpb.RangeDescriptor) {
-	b.Del(key)
+func delMeta(p *addressingPlan, key roachpb.Key, desc *roachpb.RangeDescriptor) {
+	*p = append(*p, AddressingOp{Key: key})
+}
+
+// AddressingOp is a single update of a range addressing record, as
+// returned by PlanSplitAddressing and PlanMergeAddressing.
+type AddressingOp struct {
+	Key roachpb.Key
+	// Desc is the descriptor written to Key, or nil if Key is deleted.
+	Desc *roachpb.RangeDescriptor
+}
+
+// String formats the op for logging.
+func (op AddressingOp) String() string {
+	if op.Desc == nil {
+		return fmt.Sprintf("del %s", op.Key)
+	}
+	return fmt.Sprintf("put %s -> [%s,%s)", op.Key, op.Desc.StartKey, op.Desc.EndKey)
+}
+
+// addressingPlan is a list of range addressing updates, in the order in
+// which they are applied.
+type addressingPlan []AddressingOp
+
+// addTo adds the updates to the batch.
+func (p addressingPlan) addTo(b *client.Batch) {
+	for _, op := range p {
+		if op.Desc == nil {
+			b.Del(op.Key)
+		} else {
+			b.Put(op.Key, op.Desc)
+		}
+	}
+}
+
+// PlanSplitAddressing returns the meta1 and meta2 range addressing
+// updates for a split of a range into left and right, in order, without
+// applying them. The right range must start where the left range ends.
+// The updates are exactly those splitRangeAddressing adds to the batch
+// of a split.
+func PlanSplitAddressing(left, right *roachpb.RangeDescriptor) ([]AddressingOp, error) {
+	if !left.StartKey.Less(left.EndKey) || !right.StartKey.Less(right.EndKey) {
+		return nil, util.Errorf("split ranges must have start key < end key: [%s,%s), [%s,%s)",
+			left.StartKey, left.EndKey, right.StartKey, right.EndKey)
+	}
+	if !left.EndKey.Equal(right.StartKey) {
+		return nil, util.Errorf("split ranges [%s,%s) and [%s,%s) are not adjacent",
+			left.StartKey, left.EndKey, right.StartKey, right.EndKey)
+	}
+	var p addressingPlan
+	if err := rangeAddressing(&p, left, putMeta); err != nil {
+		return nil, err
+	}
+	if err := rangeAddressing(&p, right, putMeta); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PlanMergeAddressing returns the meta1 and meta2 range addressing
+// updates for a merge of two or more adjacent ranges, given in key
+// order, into the range described by merged, in order, without applying
+// them. The merged range must span exactly the given ranges. Only the
+// records which don't also address the merged range are deleted, and
+// each record of the merged range is written once. The updates are
+// exactly those mergeRangeAddressing adds to the batch of a merge.
+func PlanMergeAddressing(merged *roachpb.RangeDescriptor, descs []*roachpb.RangeDescriptor) ([]AddressingOp, error) {
+	if len(descs) < 2 {
+		return nil, util.Errorf("merge requires at least two ranges; got %d", len(descs))
+	}
+	for i, desc := range descs {
+		if !desc.StartKey.Less(desc.EndKey) {
+			return nil, util.Errorf("merged ranges must have start key < end key: [%s,%s)",
+				desc.StartKey, desc.EndKey)
+		}
+		if i > 0 && !descs[i-1].EndKey.Equal(desc.StartKey) {
+			return nil, util.Errorf("merged ranges [%s,%s) and [%s,%s) are not adjacent",
+				descs[i-1].StartKey, descs[i-1].EndKey, desc.StartKey, desc.EndKey)
+		}
+	}
+	if last := descs[len(descs)-1]; !merged.StartKey.Equal(descs[0].StartKey) || !merged.EndKey.Equal(last.EndKey) {
+		return nil, util.Errorf("merged range [%s,%s) does not span [%s,%s)",
+			merged.StartKey, merged.EndKey, descs[0].StartKey, last.EndKey)
+	}
+
+	var before, after addressingPlan
+	for _, desc := range descs {
+		if err := rangeAddressing(&before, desc, putMeta); err != nil {
+			return nil, err
+		}
+	}
+	if err := rangeAddressing(&after, merged, putMeta); err != nil {
+		return nil, err
+	}
+	keep := map[string]struct{}{}
+	for _, op := range after {
+		keep[string(op.Key)] = struct{}{}
+	}
+	var p addressingPlan
+	for _, op := range before {
+		if _, ok := keep[string(op.Key)]; !ok {
+			// Mark the key so that it's only deleted once.
+			keep[string(op.Key)] = struct{}{}
+			delMeta(&p, op.Key, op.Desc)
+		}
+	}
+	return append(p, after...), nil
+}
+
+// RebuildRangeAddressing replaces all range addressing records with
+// those of the given range descriptors, which must be sorted by key and
+// cover the keyspace without gaps or overlaps. It is meant to repair
+// records which have become inconsistent with the authoritative range
+// descriptors. The update is applied in a single transaction.
+func RebuildRangeAddressing(db *client.DB, descs []*roachpb.RangeDescriptor) error {
+	end := roachpb.KeyMin
+	for _, desc := range descs {
+		if !desc.StartKey.Equal(end) {
+			return util.Errorf("range [%s,%s) does not start at %s", desc.StartKey, desc.EndKey, end)
+		}
+		if !desc.StartKey.Less(desc.EndKey) {
+			return util.Errorf("range must have start key < end key: [%s,%s)", desc.StartKey, desc.EndKey)
+		}
+		end = desc.EndKey
+	}
+	if !end.Equal(roachpb.KeyMax) {
+		return util.Errorf("ranges end at %s instead of %s", end, roachpb.KeyMax)
+	}
+	return db.Txn(func(txn *client.Txn) error {
+		// The old records are cleared in a batch of their own, since the
+		// puts below overlap the deleted span and would otherwise be
+		// subject to the order in which the batch is executed.
+		b := txn.NewBatch()
+		b.DelRange(keys.MetaPrefix, keys.MetaMax)
+		if err := txn.Run(b); err != nil {
+			return err
+		}
+		b = txn.NewBatch()
+		for _, desc := range descs {
+			if err := updateRangeAddressing(b, desc); err != nil {
+				return err
+			}
+		}
+		return txn.CommitInBatch(b)
+	})
+}
+
+// LookupRange returns the descriptor of the range containing key by
+// scanning the range addressing records. The descriptor of a range is
+// addressed by the first record following the key's meta key (see
+// keys.RangeMetaKey), since records are indexed by the range's end key.
+// Ordinary keys are addressed by meta2 records and meta2 keys by meta1
+// records; the scan itself is routed to the range holding the meta
+// records in the same way.
+func LookupRange(db *client.DB, key roachpb.Key) (*roachpb.RangeDescriptor, error) {
+	startKey, endKey, err := keys.MetaScanBounds(keys.RangeMetaKey(key))
+	if err != nil {
+		return nil, err
+	}
+	descs, err := ScanRangeDescriptors(db, startKey, endKey, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(descs) == 0 {
+		return nil, util.Errorf("no range addressing record found for key %s", key)
+	}
+	desc := descs[0]
+	if !desc.ContainsKey(keys.KeyAddress(key)) {
+		return nil, util.Errorf("range addressing record for [%s,%s) does not contain key %s",
+			desc.StartKey, desc.EndKey, key)
+	}
+	return desc, nil
+}
+
+// ScanRangeDescriptors scans the range addressing records in [start,
+// end), returning at most max decoded descriptors (all of them if max
+// is zero).
+func ScanRangeDescriptors(db *client.DB, start, end roachpb.Key, max int64) ([]*roachpb.RangeDescriptor, error) {
+	kvs, err := db.Scan(start, end, max)
+	if err != nil {
+		return nil, err
+	}
+	descs := make([]*roachpb.RangeDescriptor, 0, len(kvs))
+	for _, kv := range kvs {
+		desc := &roachpb.RangeDescriptor{}
+		if err := kv.ValueProto(desc); err != nil {
+			return nil, util.Errorf("unable to unmarshal range descriptor at %s: %s", kv.Key, err)
+		}
+		descs = append(descs, desc)
+	}
+	return descs, nil
 }
 
 // splitRangeAddressing creates (or overwrites if necessary) the meta1
 // and meta2 range addressing records for the left and right ranges
-// caused by a split.
+// caused by a split, as planned by PlanSplitAddressing. The records are
+// only added to the batch, which the caller must run transactionally
+// along with the corresponding range descriptor updates; running it
+// non-transactionally may leave meta1 and meta2 records inconsistent
+// with each other if it spans ranges.
 func splitRangeAddressing(b *client.Batch, left, right *roachpb.RangeDescriptor) error {
-	if err := rangeAddressing(b, left, putMeta); err != nil {
+	ops, err := PlanSplitAddressing(left, right)
+	if err != nil {
 		return err
 	}
-	return rangeAddressing(b, right, putMeta)
+	addressingPlan(ops).addTo(b)
+	return nil
 }
 
 // mergeRangeAddressing removes subsumed meta1 and meta2 range
 // addressing records caused by merging and updates the records for
-// the new merged range. Left is the range descriptor for the "left"
-// range before merging and merged describes the left to right merge.
-func mergeRangeAddressing(b *client.Batch, left, merged *roachpb.RangeDescriptor) error {
-	if err := rangeAddressing(b, left, delMeta); err != nil {
+// the new merged range, as planned by PlanMergeAddressing. Descs are
+// the range descriptors of the ranges before merging, in key order, and
+// merged describes the range they are merged into. As with
+// splitRangeAddressing, the batch must be run transactionally.
+func mergeRangeAddressing(b *client.Batch, merged *roachpb.RangeDescriptor, descs []*roachpb.RangeDescriptor) error {
+	ops, err := PlanMergeAddressing(merged, descs)
+	if err != nil {
 		return err
 	}
-	return rangeAddressing(b, merged, putMeta)
+	addressingPlan(ops).addTo(b)
+	return nil
 }
 
 // updateRangeAddressing overwrites the meta1 and meta2 range addressing
 // records for the descriptor.
 func updateRangeAddressing(b *client.Batch, desc *roachpb.RangeDescriptor) error {
-	return rangeAddressing(b, desc, putMeta)
+	var p addressingPlan
+	if err := rangeAddressing(&p, desc, putMeta); err != nil {
+		return err
+	}
+	p.addTo(b)
+	return nil
 }
 
 // rangeAddressing updates or deletes the range addressing metadata
@@ -77,7 +282,7 @@ func updateRangeAddressing(b *client.Batch, desc *roachpb.RangeDescriptor) error
 //     - meta2(desc.EndKey)
 //     3a. If desc.StartKey is KeyMin or meta2:
 //         - meta1(KeyMax)
-func rangeAddressing(b *client.Batch, desc *roachpb.RangeDescriptor, action metaAction) error {
+func rangeAddressing(p *addressingPlan, desc *roachpb.RangeDescriptor, action metaAction) error {
 	// 1. handle illegal case of start or end key being meta1.
 	if bytes.HasPrefix(desc.EndKey, keys.Meta1Prefix) ||
 		bytes.HasPrefix(desc.StartKey, keys.Meta1Prefix) {
@@ -87,16 +292,16 @@ func rangeAddressing(b *client.Batch, desc *roachpb.RangeDescriptor, action meta
 	// the range is full of meta2. We must update the relevant meta1
 	// entry pointing to the end of this range.
 	if bytes.HasPrefix(desc.EndKey, keys.Meta2Prefix) {
-		action(b, keys.RangeMetaKey(desc.EndKey), desc)
+		action(p, keys.RangeMetaKey(desc.EndKey), desc)
 	} else {
 		// 3. the range ends with a normal user key, so we must update the
 		// relevant meta2 entry pointing to the end of this range.
-		action(b, keys.MakeKey(keys.Meta2Prefix, desc.EndKey), desc)
+		action(p, keys.MakeKey(keys.Meta2Prefix, desc.EndKey), desc)
 		// 3a. the range starts with KeyMin or a meta2 addressing record,
 		// update the meta1 entry for KeyMax.
 		if bytes.Equal(desc.StartKey, roachpb.KeyMin) ||
 			bytes.HasPrefix(desc.StartKey, keys.Meta2Prefix) {
-			action(b, keys.MakeKey(keys.Meta1Prefix, roachpb.KeyMax), desc)
+			action(p, keys.MakeKey(keys.Meta1Prefix, roachpb.KeyMax), desc)
 		}
 	}
 	return nil
@@ -19,14 +19,17 @@ package storage
 
 import (
 	"fmt"
+	"math/rand"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/cockroachdb/cockroach/client"
 	"github.com/cockroachdb/cockroach/keys"
 	"github.com/cockroachdb/cockroach/roachpb"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/testutils"
 	"github.com/cockroachdb/cockroach/util/leaktest"
 	"github.com/cockroachdb/cockroach/util/log"
 	"github.com/gogo/protobuf/proto"
@@ -120,8 +123,14 @@ func TestUpdateRangeAddressing(t *testing.T) {
 			if err := splitRangeAddressing(b, left, right); err != nil {
 				t.Fatal(err)
 			}
+		} else if test.leftEnd.Equal(test.rightEnd) {
+			// Nothing to merge; write the records of the whole range.
+			if err := updateRangeAddressing(b, right); err != nil {
+				t.Fatal(err)
+			}
 		} else {
-			if err := mergeRangeAddressing(b, left, right); err != nil {
+			subsumed := &roachpb.RangeDescriptor{StartKey: test.leftEnd, EndKey: test.rightEnd}
+			if err := mergeRangeAddressing(b, right, []*roachpb.RangeDescriptor{left, subsumed}); err != nil {
 				t.Fatal(err)
 			}
 		}
@@ -129,10 +138,23 @@ func TestUpdateRangeAddressing(t *testing.T) {
 			t.Fatal(err)
 		}
 		// Scan meta keys directly from engine.
-		kvs, _, err := engine.MVCCScan(store.Engine(), keys.MetaPrefix, keys.MetaMax, 0, roachpb.MaxTimestamp, true, nil)
+		kvs, _, _, err := engine.MVCCScan(store.Engine(), keys.MetaPrefix, keys.MetaMax, 0, roachpb.MaxTimestamp, true, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
+		// Scanning in pages of two must yield the same records.
+		var pagedKVs []roachpb.KeyValue
+		for start := keys.MetaPrefix; start != nil; {
+			var page []roachpb.KeyValue
+			page, start, _, err = engine.MVCCScan(store.Engine(), start, keys.MetaMax, 2, roachpb.MaxTimestamp, true, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pagedKVs = append(pagedKVs, page...)
+		}
+		if !reflect.DeepEqual(kvs, pagedKVs) {
+			t.Errorf("%d: paged scan returned %v; expected %v", i, pagedKVs, kvs)
+		}
 		metas := metaSlice{}
 		for _, kv := range kvs {
 			scannedDesc := &roachpb.RangeDescriptor{}
@@ -209,3 +231,406 @@ func TestUpdateRangeAddressingSplitMeta1(t *testing.T) {
 		t.Error("expected failure trying to update addressing records for meta1 split")
 	}
 }
+
+// TestPlanRangeAddressing verifies that the planned range addressing
+// updates match those applied for the same split or merge.
+func TestPlanRangeAddressing(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	a, m := roachpb.Key("a"), roachpb.Key("m")
+	desc := func(start, end roachpb.Key) *roachpb.RangeDescriptor {
+		return &roachpb.RangeDescriptor{StartKey: start, EndKey: end}
+	}
+	testCases := []struct {
+		merge       bool
+		left, right *roachpb.RangeDescriptor
+	}{
+		{false, desc(roachpb.KeyMin, a), desc(a, roachpb.KeyMax)},
+		{false, desc(roachpb.KeyMin, meta2Key(m)), desc(meta2Key(m), a)},
+		{true, desc(roachpb.KeyMin, meta2Key(m)), desc(meta2Key(m), a)},
+	}
+	for i, test := range testCases {
+		var ops []AddressingOp
+		var err error
+		b := &client.Batch{}
+		if test.merge {
+			merged := desc(test.left.StartKey, test.right.EndKey)
+			descs := []*roachpb.RangeDescriptor{test.left, test.right}
+			ops, err = PlanMergeAddressing(merged, descs)
+			if err == nil {
+				err = mergeRangeAddressing(b, merged, descs)
+			}
+		} else {
+			ops, err = PlanSplitAddressing(test.left, test.right)
+			if err == nil {
+				err = splitRangeAddressing(b, test.left, test.right)
+			}
+		}
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(b.Results) != len(ops) {
+			t.Errorf("%d: planned %d updates, but batch has %d", i, len(ops), len(b.Results))
+		}
+		if err := store.DB().Run(b); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		// The last op for each key determines its state after the batch.
+		final := map[string]*roachpb.RangeDescriptor{}
+		for _, op := range ops {
+			final[string(op.Key)] = op.Desc
+		}
+		for key, expDesc := range final {
+			actDesc := &roachpb.RangeDescriptor{}
+			ok, err := engine.MVCCGetProto(store.Engine(), roachpb.Key(key), roachpb.MaxTimestamp, true, nil, actDesc)
+			if err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			if expDesc == nil {
+				if ok {
+					t.Errorf("%d: planned deletion of %s, but found %+v", i, roachpb.Key(key), actDesc)
+				}
+			} else if !ok || !reflect.DeepEqual(expDesc, actDesc) {
+				t.Errorf("%d: planned %+v at %s, but found %+v", i, expDesc, roachpb.Key(key), actDesc)
+			}
+		}
+	}
+
+	expOps := []AddressingOp{
+		{Key: meta2Key(a), Desc: testCases[0].left},
+		{Key: meta1Key(roachpb.KeyMax), Desc: testCases[0].left},
+		{Key: meta2Key(roachpb.KeyMax), Desc: testCases[0].right},
+	}
+	if ops, err := PlanSplitAddressing(testCases[0].left, testCases[0].right); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ops, expOps) {
+		t.Errorf("expected %v; got %v", expOps, ops)
+	}
+}
+
+// TestRangeAddressingValidation verifies that split and merge addressing
+// updates are rejected unless the descriptors are correctly ordered and
+// adjacent.
+func TestRangeAddressingValidation(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	a, m, z := roachpb.Key("a"), roachpb.Key("m"), roachpb.Key("z")
+	testCases := []struct {
+		split                bool
+		leftStart, leftEnd   roachpb.Key
+		rightStart, rightEnd roachpb.Key
+		expErr               string
+	}{
+		// Valid split and merge.
+		{true, a, m, m, z, ""},
+		{false, a, m, m, z, ""},
+		// Gap between split ranges.
+		{true, a, m, m.Next(), z, "not adjacent"},
+		// Overlapping split ranges.
+		{true, a, m, a, z, "not adjacent"},
+		// Split ranges in reversed order.
+		{true, m, z, a, m, "not adjacent"},
+		// Reversed bounds.
+		{true, m, a, a, z, "start key < end key"},
+		{true, a, m, z, m, "start key < end key"},
+		{false, m, a, a, z, "start key < end key"},
+		// Gap between merged ranges.
+		{false, a, m, m.Next(), z, "not adjacent"},
+	}
+	for i, test := range testCases {
+		left := &roachpb.RangeDescriptor{StartKey: test.leftStart, EndKey: test.leftEnd}
+		right := &roachpb.RangeDescriptor{StartKey: test.rightStart, EndKey: test.rightEnd}
+		var err error
+		if test.split {
+			err = splitRangeAddressing(&client.Batch{}, left, right)
+		} else {
+			merged := &roachpb.RangeDescriptor{StartKey: test.leftStart, EndKey: test.rightEnd}
+			err = mergeRangeAddressing(&client.Batch{}, merged, []*roachpb.RangeDescriptor{left, right})
+		}
+		if test.expErr == "" {
+			if err != nil {
+				t.Errorf("%d: unexpected error: %s", i, err)
+			}
+		} else if !testutils.IsError(err, test.expErr) {
+			t.Errorf("%d: expected error %q; got %v", i, test.expErr, err)
+		}
+	}
+}
+
+// checkRangeAddressing verifies that the range addressing records in
+// the engine are exactly those of the given ranges, which are sorted by
+// key, and that the ranges they describe cover the keyspace without
+// gaps or overlaps.
+func checkRangeAddressing(e engine.Engine, descs []*roachpb.RangeDescriptor) error {
+	var plan addressingPlan
+	for _, desc := range descs {
+		if err := rangeAddressing(&plan, desc, putMeta); err != nil {
+			return err
+		}
+	}
+	expMetas := map[string]*roachpb.RangeDescriptor{}
+	for _, op := range plan {
+		if _, ok := expMetas[string(op.Key)]; ok {
+			return fmt.Errorf("ranges %v have multiple records at %s", descs, op.Key)
+		}
+		expMetas[string(op.Key)] = op.Desc
+	}
+
+	kvs, _, _, err := engine.MVCCScan(e, keys.MetaPrefix, keys.MetaMax, 0, roachpb.MaxTimestamp, true, nil)
+	if err != nil {
+		return err
+	}
+	if len(kvs) != len(expMetas) {
+		return fmt.Errorf("expected %d records; found %d", len(expMetas), len(kvs))
+	}
+	addressed := map[roachpb.RangeID]*roachpb.RangeDescriptor{}
+	for _, kv := range kvs {
+		desc := &roachpb.RangeDescriptor{}
+		if err := proto.Unmarshal(kv.Value.Bytes, desc); err != nil {
+			return err
+		}
+		if expDesc, ok := expMetas[string(kv.Key)]; !ok {
+			return fmt.Errorf("unexpected record at %s: %+v", kv.Key, desc)
+		} else if !reflect.DeepEqual(expDesc, desc) {
+			return fmt.Errorf("expected %+v at %s; found %+v", expDesc, kv.Key, desc)
+		}
+		addressed[desc.RangeID] = desc
+	}
+
+	var covered []*roachpb.RangeDescriptor
+	for _, desc := range addressed {
+		covered = append(covered, desc)
+	}
+	sort.Sort(rangeDescsByStartKey(covered))
+	end := roachpb.KeyMin
+	for _, desc := range covered {
+		if !desc.StartKey.Equal(end) {
+			return fmt.Errorf("addressed ranges %v leave a gap or overlap at %s", covered, end)
+		}
+		end = desc.EndKey
+	}
+	if !end.Equal(roachpb.KeyMax) {
+		return fmt.Errorf("addressed ranges %v end at %s", covered, end)
+	}
+	return nil
+}
+
+type rangeDescsByStartKey []*roachpb.RangeDescriptor
+
+func (r rangeDescsByStartKey) Len() int           { return len(r) }
+func (r rangeDescsByStartKey) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r rangeDescsByStartKey) Less(i, j int) bool { return r[i].StartKey.Less(r[j].StartKey) }
+
+// TestRangeAddressingRandomized verifies that the range addressing
+// records remain consistent with the ranges over a random sequence of
+// splits and merges. The sequence is printed on failure; it is
+// determined by the seed.
+func TestRangeAddressingRandomized(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	const seed = 1
+	const numSteps = 200
+	rng := rand.New(rand.NewSource(seed))
+
+	// Ranges may be split at user and meta2 keys, but not at meta1 keys.
+	var splitKeys []roachpb.Key
+	for c := 'a'; c <= 'z'; c++ {
+		key := roachpb.Key(string(c))
+		splitKeys = append(splitKeys, key, meta2Key(key))
+	}
+
+	descs := []*roachpb.RangeDescriptor{{RangeID: 1, StartKey: roachpb.KeyMin, EndKey: roachpb.KeyMax}}
+	nextRangeID := roachpb.RangeID(2)
+	b := &client.Batch{}
+	if err := updateRangeAddressing(b, descs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DB().Run(b); err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []string
+	for i := 0; i < numSteps; i++ {
+		b := &client.Batch{}
+		var err error
+		if len(descs) > 1 && rng.Intn(3) == 0 {
+			j := rng.Intn(len(descs) - 1)
+			left, right := descs[j], descs[j+1]
+			merged := &roachpb.RangeDescriptor{RangeID: left.RangeID, StartKey: left.StartKey, EndKey: right.EndKey}
+			steps = append(steps, fmt.Sprintf("merge [%s,%s) and [%s,%s)",
+				left.StartKey, left.EndKey, right.StartKey, right.EndKey))
+			err = mergeRangeAddressing(b, merged, []*roachpb.RangeDescriptor{left, right})
+			descs = append(descs[:j], append([]*roachpb.RangeDescriptor{merged}, descs[j+2:]...)...)
+		} else {
+			key := splitKeys[rng.Intn(len(splitKeys))]
+			j := sort.Search(len(descs), func(j int) bool { return key.Less(descs[j].EndKey) })
+			desc := descs[j]
+			if desc.StartKey.Equal(key) {
+				continue
+			}
+			left := &roachpb.RangeDescriptor{RangeID: desc.RangeID, StartKey: desc.StartKey, EndKey: key}
+			right := &roachpb.RangeDescriptor{RangeID: nextRangeID, StartKey: key, EndKey: desc.EndKey}
+			nextRangeID++
+			steps = append(steps, fmt.Sprintf("split [%s,%s) at %s", desc.StartKey, desc.EndKey, key))
+			err = splitRangeAddressing(b, left, right)
+			descs = append(descs[:j], append([]*roachpb.RangeDescriptor{left, right}, descs[j+1:]...)...)
+		}
+		if err == nil {
+			err = store.DB().Run(b)
+		}
+		if err == nil {
+			err = checkRangeAddressing(store.Engine(), descs)
+		}
+		if err != nil {
+			t.Fatalf("seed %d: %s after:\n%s", seed, err, strings.Join(steps, "\n"))
+		}
+	}
+}
+
+// TestMergeRangesAddressing verifies that merging several adjacent
+// ranges at once leaves exactly the addressing records of the merged
+// range in place of theirs, writing each of them once.
+func TestMergeRangesAddressing(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	a, m, r, z := roachpb.Key("a"), roachpb.Key("m"), roachpb.Key("r"), roachpb.Key("z")
+	testCases := []struct {
+		splitKeys []roachpb.Key
+		// The ranges [first,last] are merged.
+		first, last int
+	}{
+		// Ranges addressed by meta2.
+		{[]roachpb.Key{a, m, r, z}, 1, 3},
+		{[]roachpb.Key{a, m, r}, 1, 3},
+		// Ranges spanning meta2 and user keys, addressed by meta1 and meta2.
+		{[]roachpb.Key{meta2Key(m), a, m}, 0, 2},
+		{[]roachpb.Key{meta2Key(m), meta2Key(r), a}, 1, 3},
+	}
+	for i, test := range testCases {
+		func() {
+			store, _, stopper := createTestStore(t)
+			defer stopper.Stop()
+
+			var descs []*roachpb.RangeDescriptor
+			start := roachpb.KeyMin
+			for j, end := range append(test.splitKeys, roachpb.KeyMax) {
+				descs = append(descs, &roachpb.RangeDescriptor{RangeID: roachpb.RangeID(j + 1), StartKey: start, EndKey: end})
+				start = end
+			}
+			b := &client.Batch{}
+			for _, desc := range descs {
+				if err := updateRangeAddressing(b, desc); err != nil {
+					t.Fatalf("%d: %s", i, err)
+				}
+			}
+			if err := store.DB().Run(b); err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+
+			toMerge := descs[test.first : test.last+1]
+			merged := *toMerge[0]
+			merged.EndKey = toMerge[len(toMerge)-1].EndKey
+			plan, err := PlanMergeAddressing(&merged, toMerge)
+			if err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			seen := map[string]struct{}{}
+			for _, op := range plan {
+				if _, ok := seen[string(op.Key)]; ok {
+					t.Errorf("%d: %s updated more than once in %v", i, op.Key, plan)
+				}
+				seen[string(op.Key)] = struct{}{}
+			}
+
+			b = &client.Batch{}
+			if err := mergeRangeAddressing(b, &merged, toMerge); err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			if err := store.DB().Run(b); err != nil {
+				t.Fatalf("%d: %s", i, err)
+			}
+			expDescs := append(append(append([]*roachpb.RangeDescriptor(nil),
+				descs[:test.first]...), &merged), descs[test.last+1:]...)
+			if err := checkRangeAddressing(store.Engine(), expDescs); err != nil {
+				t.Errorf("%d: %s", i, err)
+			}
+		}()
+	}
+
+	a1, a2, a3 := &roachpb.RangeDescriptor{StartKey: a, EndKey: m}, &roachpb.RangeDescriptor{StartKey: m, EndKey: r},
+		&roachpb.RangeDescriptor{StartKey: r, EndKey: z}
+	az := &roachpb.RangeDescriptor{StartKey: a, EndKey: z}
+	if err := mergeRangeAddressing(&client.Batch{}, az, []*roachpb.RangeDescriptor{a1, a3}); !testutils.IsError(err, "not adjacent") {
+		t.Errorf("expected adjacency error; got %v", err)
+	}
+	if err := mergeRangeAddressing(&client.Batch{}, az, []*roachpb.RangeDescriptor{a1}); !testutils.IsError(err, "at least two ranges") {
+		t.Errorf("expected error merging a single range; got %v", err)
+	}
+	if err := mergeRangeAddressing(&client.Batch{}, az, []*roachpb.RangeDescriptor{a1, a2}); !testutils.IsError(err, "does not span") {
+		t.Errorf("expected error for merged range not spanning the ranges; got %v", err)
+	}
+}
+
+// TestRebuildRangeAddressing verifies that rebuilding the range
+// addressing records restores missing records and removes stale ones,
+// and that it rejects descriptors which don't cover the keyspace.
+func TestRebuildRangeAddressing(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	a, m, z := roachpb.Key("a"), roachpb.Key("m"), roachpb.Key("z")
+	var descs []*roachpb.RangeDescriptor
+	start := roachpb.KeyMin
+	for i, end := range []roachpb.Key{meta2Key(m), a, m, roachpb.KeyMax} {
+		descs = append(descs, &roachpb.RangeDescriptor{RangeID: roachpb.RangeID(i + 1), StartKey: start, EndKey: end})
+		start = end
+	}
+	b := &client.Batch{}
+	for _, desc := range descs {
+		if err := updateRangeAddressing(b, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Drop some of the records and add a stale one.
+	b.Del(meta1Key(roachpb.KeyMax), meta2Key(a))
+	b.Put(meta2Key(z), descs[3])
+	if err := store.DB().Run(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRangeAddressing(store.Engine(), descs); err == nil {
+		t.Fatal("expected damaged records to be inconsistent")
+	}
+
+	if err := RebuildRangeAddressing(store.DB(), descs); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRangeAddressing(store.Engine(), descs); err != nil {
+		t.Fatal(err)
+	}
+
+	desc := func(start, end roachpb.Key) *roachpb.RangeDescriptor {
+		return &roachpb.RangeDescriptor{StartKey: start, EndKey: end}
+	}
+	testCases := []struct {
+		descs  []*roachpb.RangeDescriptor
+		expErr string
+	}{
+		{nil, "ranges end at"},
+		{[]*roachpb.RangeDescriptor{desc(a, roachpb.KeyMax)}, "does not start at"},
+		{[]*roachpb.RangeDescriptor{desc(roachpb.KeyMin, a), desc(m, roachpb.KeyMax)}, "does not start at"},
+		{[]*roachpb.RangeDescriptor{desc(roachpb.KeyMin, m), desc(m, z)}, "ranges end at"},
+		{[]*roachpb.RangeDescriptor{desc(roachpb.KeyMin, m), desc(m, m), desc(m, roachpb.KeyMax)}, "start key < end key"},
+	}
+	for i, test := range testCases {
+		if err := RebuildRangeAddressing(store.DB(), test.descs); !testutils.IsError(err, test.expErr) {
+			t.Errorf("%d: expected error %q; got %v", i, test.expErr, err)
+		}
+	}
+	// The failed rebuilds left the records alone.
+	if err := checkRangeAddressing(store.Engine(), descs); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// storeOverloadedError is returned for requests which were not admitted
+// to a store because too many requests were already waiting. It's
+// retryable: the client backs off and tries again.
+type storeOverloadedError struct {
+	queued int
+}
+
+func (e *storeOverloadedError) Error() string {
+	return fmt.Sprintf("store overloaded: %d requests waiting for admission", e.queued)
+}
+
+// CanRetry implements the retry.Retryable interface.
+func (e *storeOverloadedError) CanRetry() bool { return true }
+
+// AdmissionMetrics holds the state of a store's admission queue at the
+// time it was retrieved.
+type AdmissionMetrics struct {
+	// Running is the number of requests currently admitted, and Queued
+	// the number of requests waiting to be admitted.
+	Running, Queued int64
+	// Admitted is the number of requests which were admitted after
+	// waiting in the queue, and Shed the number of requests which were
+	// turned away because the queue was full.
+	Admitted, Shed int64
+}
+
+// An admissionWaiter is a request waiting in an admissionQueue.
+type admissionWaiter struct {
+	userPriority, txnPriority int32
+	seq                       int64
+	index                     int        // Index in the heap; -1 once removed
+	done                      chan error // Receives nil once admitted
+}
+
+// outranks returns true if w should be admitted before o: requests are
+// ordered by user priority, then by transaction priority, then by
+// arrival.
+func (w *admissionWaiter) outranks(o *admissionWaiter) bool {
+	if w.userPriority != o.userPriority {
+		return w.userPriority > o.userPriority
+	}
+	if w.txnPriority != o.txnPriority {
+		return w.txnPriority > o.txnPriority
+	}
+	return w.seq < o.seq
+}
+
+// admissionHeap implements heap.Interface, with the waiter to admit
+// next at the root.
+type admissionHeap []*admissionWaiter
+
+func (h admissionHeap) Len() int           { return len(h) }
+func (h admissionHeap) Less(i, j int) bool { return h[i].outranks(h[j]) }
+func (h admissionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *admissionHeap) Push(x interface{}) {
+	w := x.(*admissionWaiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *admissionHeap) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
+
+// An admissionQueue limits the number of requests executing on a store
+// concurrently. Requests beyond that limit wait in priority order, so
+// that low-priority work can't starve more important requests; once too
+// many are waiting, the lowest-priority ones are shed.
+type admissionQueue struct {
+	mu        sync.Mutex
+	slots     int // Maximum number of running requests; 0 for no limit
+	maxQueued int // Maximum number of waiting requests; 0 for no limit
+	running   int
+	waiters   admissionHeap
+	seq       int64
+	admitted  int64
+	shed      int64
+}
+
+func newAdmissionQueue(slots, maxQueued int) *admissionQueue {
+	return &admissionQueue{slots: slots, maxQueued: maxQueued}
+}
+
+// admit blocks until a request with the given priorities may execute,
+// and returns an error if it was shed instead or if the context was
+// canceled while waiting. Every successful call must be followed by a
+// call to release.
+func (q *admissionQueue) admit(ctx context.Context, userPriority, txnPriority int32) error {
+	q.mu.Lock()
+	if q.slots == 0 || (q.running < q.slots && len(q.waiters) == 0) {
+		q.running++
+		q.mu.Unlock()
+		return nil
+	}
+	q.seq++
+	w := &admissionWaiter{
+		userPriority: userPriority,
+		txnPriority:  txnPriority,
+		seq:          q.seq,
+		done:         make(chan error, 1),
+	}
+	if q.maxQueued > 0 && len(q.waiters) >= q.maxQueued {
+		// Shed the lowest-priority request: either the new one, or the
+		// lowest-priority waiter if the new one outranks it.
+		lowest := 0
+		for i := range q.waiters {
+			if q.waiters[lowest].outranks(q.waiters[i]) {
+				lowest = i
+			}
+		}
+		q.shed++
+		err := &storeOverloadedError{queued: len(q.waiters)}
+		if !w.outranks(q.waiters[lowest]) {
+			q.mu.Unlock()
+			return err
+		}
+		heap.Remove(&q.waiters, lowest).(*admissionWaiter).done <- err
+	}
+	heap.Push(&q.waiters, w)
+	q.mu.Unlock()
+
+	select {
+	case err := <-w.done:
+		return err
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	if w.index >= 0 {
+		heap.Remove(&q.waiters, w.index)
+		q.mu.Unlock()
+		return ctx.Err()
+	}
+	q.mu.Unlock()
+	// We were admitted or shed concurrently with the cancellation; give
+	// up the slot in the former case.
+	if err := <-w.done; err == nil {
+		q.release()
+	}
+	return ctx.Err()
+}
+
+// release ends the execution of an admitted request, admitting the
+// highest-priority waiter in its place.
+func (q *admissionQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) > 0 {
+		q.admitted++
+		heap.Pop(&q.waiters).(*admissionWaiter).done <- nil
+		return
+	}
+	q.running--
+}
+
+// metrics returns a snapshot of the queue's state.
+func (q *admissionQueue) metrics() AdmissionMetrics {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return AdmissionMetrics{
+		Running:  int64(q.running),
+		Queued:   int64(len(q.waiters)),
+		Admitted: q.admitted,
+		Shed:     q.shed,
+	}
+}
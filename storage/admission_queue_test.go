@@ -0,0 +1,99 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/leaktest"
+)
+
+// TestAdmissionQueue verifies that waiting requests are admitted in
+// priority order and that the lowest-priority requests are shed once
+// the queue is full.
+func TestAdmissionQueue(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	q := newAdmissionQueue(1, 2)
+	if err := q.admit(context.Background(), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan int32, 3)
+	errs := make(chan error, 3)
+	enqueue := func(userPriority, txnPriority int32, queued int64) {
+		go func() {
+			if err := q.admit(context.Background(), userPriority, txnPriority); err != nil {
+				errs <- err
+				return
+			}
+			admitted <- 10*userPriority + txnPriority
+		}()
+		util.SucceedsWithin(t, time.Second, func() error {
+			if m := q.metrics(); m.Queued != queued {
+				return util.Errorf("expected %d queued requests; got %+v", queued, m)
+			}
+			return nil
+		})
+	}
+	enqueue(1, 1, 1)
+	enqueue(2, 0, 2)
+	// The queue is full: a lower-priority request is turned away...
+	if err := q.admit(context.Background(), 1, 0); err == nil {
+		t.Fatal("expected request to be shed")
+	} else if _, ok := err.(*storeOverloadedError); !ok {
+		t.Fatalf("expected store overloaded error; got %v", err)
+	}
+	// ...while a higher-priority one replaces the lowest-priority waiter.
+	enqueue(1, 5, 2)
+	if err := <-errs; err == nil {
+		t.Fatal("expected lowest-priority waiter to be shed")
+	}
+
+	// The remaining waiters are admitted one at a time, by priority.
+	for _, exp := range []int32{20, 15} {
+		q.release()
+		if p := <-admitted; p != exp {
+			t.Errorf("expected request with priority %d to be admitted; got %d", exp, p)
+		}
+	}
+	q.release()
+	if m := q.metrics(); m != (AdmissionMetrics{Running: 0, Queued: 0, Admitted: 2, Shed: 2}) {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+}
+
+// TestAdmissionQueueCancel verifies that a request stops waiting for
+// admission once its context is canceled.
+func TestAdmissionQueueCancel(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	q := newAdmissionQueue(1, 0)
+	if err := q.admit(context.Background(), 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := q.admit(ctx, 0, 0); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded; got %v", err)
+	}
+	q.release()
+	if m := q.metrics(); m.Running != 0 || m.Queued != 0 {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+}
@@ -34,6 +34,7 @@ import (
 	"github.com/cockroachdb/cockroach/sql"
 	"github.com/cockroachdb/cockroach/storage"
 	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/testutils"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/leaktest"
 	"github.com/cockroachdb/cockroach/util/randutil"
@@ -580,3 +581,118 @@ func TestStoreRangeSystemSplits(t *testing.T) {
 		t.Errorf("expected splits not found: %s", err)
 	}
 }
+
+// TestSplitAddressingAtomic verifies that a failure while writing the
+// addressing records for a split fails the split and leaves all
+// addressing records unchanged.
+func TestSplitAddressingAtomic(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	scanMeta := func() []roachpb.KeyValue {
+		kvs, _, _, err := engine.MVCCScan(store.Engine(), keys.MetaPrefix, keys.MetaMax, 0,
+			roachpb.MaxTimestamp, false /* !consistent */, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kvs
+	}
+	before := scanMeta()
+
+	// Fail the write of the last record, meta2(KeyMax), after the records
+	// for the left range have been written.
+	failKey := keys.MakeKey(keys.Meta2Prefix, roachpb.KeyMax)
+	storage.TestingCommandFilter = func(args roachpb.Request) error {
+		if _, ok := args.(*roachpb.PutRequest); ok && args.Header().Key.Equal(failKey) {
+			return util.Errorf("injected failure")
+		}
+		return nil
+	}
+	defer func() { storage.TestingCommandFilter = nil }()
+	args := adminSplitArgs(roachpb.KeyMin, roachpb.Key("a"), 1, store.StoreID())
+	if _, err := client.SendWrapped(store, nil, &args); !testutils.IsError(err, "injected failure") {
+		t.Fatalf("expected injected failure; got %v", err)
+	}
+	if after := scanMeta(); !reflect.DeepEqual(before, after) {
+		t.Fatalf("addressing records changed on failure:\n%v\n%v", before, after)
+	}
+	if rng := store.LookupReplica(roachpb.Key("a"), nil); rng == nil || !rng.Desc().StartKey.Equal(roachpb.KeyMin) {
+		t.Fatalf("expected range to remain unsplit; got %v", rng)
+	}
+}
+
+// TestLookupRange verifies that LookupRange resolves the descriptor of
+// the range containing ordinary keys and meta2 keys after a split.
+func TestLookupRange(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	splitKey := roachpb.Key("m")
+	args := adminSplitArgs(roachpb.KeyMin, splitKey, 1, store.StoreID())
+	if _, err := client.SendWrapped(store, nil, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		key              roachpb.Key
+		expStart, expEnd roachpb.Key
+	}{
+		{roachpb.Key("a"), roachpb.KeyMin, splitKey},
+		{splitKey, splitKey, roachpb.KeyMax},
+		{roachpb.Key("x"), splitKey, roachpb.KeyMax},
+		{keys.RangeMetaKey(roachpb.Key("x")), roachpb.KeyMin, splitKey},
+	}
+	for i, test := range testCases {
+		desc, err := storage.LookupRange(store.DB(), test.key)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if !desc.StartKey.Equal(test.expStart) || !desc.EndKey.Equal(test.expEnd) {
+			t.Errorf("%d: expected [%s,%s) for key %s; got [%s,%s)",
+				i, test.expStart, test.expEnd, test.key, desc.StartKey, desc.EndKey)
+		}
+	}
+
+	if _, err := storage.LookupRange(store.DB(), roachpb.KeyMax); err == nil {
+		t.Error("expected error looking up KeyMax")
+	}
+}
+
+// TestScanRangeDescriptors verifies that the range addressing records
+// can be scanned as decoded range descriptors.
+func TestScanRangeDescriptors(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	splitKeys := []roachpb.Key{roachpb.Key("c"), roachpb.Key("m")}
+	for _, key := range splitKeys {
+		if err := store.DB().AdminSplit(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	descs, err := storage.ScanRangeDescriptors(store.DB(), keys.Meta2Prefix, keys.Meta2Prefix.PrefixEnd(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expBounds := []roachpb.Key{roachpb.KeyMin, splitKeys[0], splitKeys[1], roachpb.KeyMax}
+	if len(descs) != len(expBounds)-1 {
+		t.Fatalf("expected %d descriptors; got %d", len(expBounds)-1, len(descs))
+	}
+	for i, desc := range descs {
+		if !desc.StartKey.Equal(expBounds[i]) || !desc.EndKey.Equal(expBounds[i+1]) {
+			t.Errorf("%d: expected [%s,%s); got [%s,%s)", i, expBounds[i], expBounds[i+1], desc.StartKey, desc.EndKey)
+		}
+	}
+
+	// A value which isn't a range descriptor yields an error.
+	if err := store.DB().Put("a", "not a descriptor"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.ScanRangeDescriptors(store.DB(), roachpb.Key("a"), roachpb.Key("b"), 0); !testutils.IsError(err, "unable to unmarshal range descriptor at \"a\"") {
+		t.Errorf("expected unmarshal error; got %v", err)
+	}
+}
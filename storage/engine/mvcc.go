@@ -958,7 +958,7 @@ func MVCCDeleteRange(engine Engine, ms *MVCCStats, key, endKey roachpb.Key, max
 	// In order to detect the potential write intent by another
 	// concurrent transaction with a newer timestamp, we need
 	// to use the max timestamp for scan.
-	kvs, _, err := MVCCScan(engine, key, endKey, max, roachpb.MaxTimestamp, true /* consistent */, txn)
+	kvs, _, _, err := MVCCScan(engine, key, endKey, max, roachpb.MaxTimestamp, true /* consistent */, txn)
 	if err != nil {
 		return 0, err
 	}
@@ -1044,11 +1044,23 @@ func mvccScanInternal(engine Engine, key, endKey roachpb.Key, max int64, timesta
 }
 
 // MVCCScan scans the key range [start,end) key up to some maximum number of
-// results in ascending order. Specify max=0 for unbounded scans.
+// results in ascending order. Specify max=0 for unbounded scans. If the
+// results were truncated at max, the returned resume key is the key at
+// which a subsequent scan of [resumeKey,end) continues; that scan may find
+// no further results. The resume key is nil for unbounded scans and for
+// scans which returned fewer than max results.
 func MVCCScan(engine Engine, key, endKey roachpb.Key, max int64, timestamp roachpb.Timestamp,
-	consistent bool, txn *roachpb.Transaction) ([]roachpb.KeyValue, []roachpb.Intent, error) {
-	return mvccScanInternal(engine, key, endKey, max, timestamp,
+	consistent bool, txn *roachpb.Transaction) ([]roachpb.KeyValue, roachpb.Key, []roachpb.Intent, error) {
+	kvs, intents, err := mvccScanInternal(engine, key, endKey, max, timestamp,
 		consistent, txn, false /* !reverse */)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var resumeKey roachpb.Key
+	if max != 0 && int64(len(kvs)) == max {
+		resumeKey = kvs[len(kvs)-1].Key.Next()
+	}
+	return kvs, resumeKey, intents, nil
 }
 
 // MVCCReverseScan scans the key range [start,end) key up to some maximum number of
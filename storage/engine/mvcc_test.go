@@ -127,10 +127,10 @@ func TestMVCCEmptyKey(t *testing.T) {
 	if err := MVCCPut(engine, nil, roachpb.Key{}, makeTS(0, 1), value1, nil); err == nil {
 		t.Error("expected empty key error")
 	}
-	if _, _, err := MVCCScan(engine, roachpb.Key{}, testKey1, 0, makeTS(0, 1), true, nil); err != nil {
+	if _, _, _, err := MVCCScan(engine, roachpb.Key{}, testKey1, 0, makeTS(0, 1), true, nil); err != nil {
 		t.Errorf("empty key allowed for start key in scan; got %s", err)
 	}
-	if _, _, err := MVCCScan(engine, testKey1, roachpb.Key{}, 0, makeTS(0, 1), true, nil); err == nil {
+	if _, _, _, err := MVCCScan(engine, testKey1, roachpb.Key{}, 0, makeTS(0, 1), true, nil); err == nil {
 		t.Error("expected empty key error")
 	}
 	if err := MVCCResolveWriteIntent(engine, nil, roachpb.Key{}, makeTS(0, 1), txn1); err == nil {
@@ -480,7 +480,7 @@ func TestMVCCGetUncertainty(t *testing.T) {
 	} else if e, ok := err.(*roachpb.ReadWithinUncertaintyIntervalError); !ok {
 		t.Fatalf("wanted a ReadWithinUncertaintyIntervalError, got %+v", e)
 	}
-	if _, _, err := MVCCScan(engine, testKey2, testKey2.PrefixEnd(), 10, makeTS(7, 0), true, txn); err == nil {
+	if _, _, _, err := MVCCScan(engine, testKey2, testKey2.PrefixEnd(), 10, makeTS(7, 0), true, txn); err == nil {
 		t.Fatal("wanted an error")
 	}
 	// Adjust MaxTimestamp and retry.
@@ -488,7 +488,7 @@ func TestMVCCGetUncertainty(t *testing.T) {
 	if _, _, err := MVCCGet(engine, testKey2, makeTS(7, 0), true, txn); err != nil {
 		t.Fatal(err)
 	}
-	if _, _, err := MVCCScan(engine, testKey2, testKey2.PrefixEnd(), 10, makeTS(7, 0), true, txn); err != nil {
+	if _, _, _, err := MVCCScan(engine, testKey2, testKey2.PrefixEnd(), 10, makeTS(7, 0), true, txn); err != nil {
 		t.Fatal(err)
 	}
 
@@ -503,7 +503,7 @@ func TestMVCCGetUncertainty(t *testing.T) {
 	if err := MVCCPut(engine, nil, testKey3, makeTS(99, 0), value2, nil); err != nil {
 		t.Fatal(err)
 	}
-	if _, _, err := MVCCScan(engine, testKey3, testKey3.PrefixEnd(), 10, makeTS(7, 0), true, txn); err == nil {
+	if _, _, _, err := MVCCScan(engine, testKey3, testKey3.PrefixEnd(), 10, makeTS(7, 0), true, txn); err == nil {
 		t.Fatal("wanted an error")
 	}
 	if _, _, err := MVCCGet(engine, testKey3, makeTS(7, 0), true, txn); err == nil {
@@ -702,7 +702,7 @@ func TestMVCCScanWriteIntentError(t *testing.T) {
 		if scan.consistent {
 			cStr = "consistent"
 		}
-		kvs, intents, err := MVCCScan(engine, testKey1, testKey4.Next(), 0, makeTS(1, 0), scan.consistent, scan.txn)
+		kvs, _, intents, err := MVCCScan(engine, testKey1, testKey4.Next(), 0, makeTS(1, 0), scan.consistent, scan.txn)
 		wiErr, _ := err.(*roachpb.WriteIntentError)
 		if (err == nil) != (wiErr == nil) {
 			t.Errorf("%s(%d): unexpected error: %s", cStr, i, err)
@@ -889,7 +889,7 @@ func TestMVCCScan(t *testing.T) {
 	err = MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil)
 	err = MVCCPut(engine, nil, testKey4, makeTS(5, 0), value1, nil)
 
-	kvs, _, err := MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, nil)
+	kvs, _, _, err := MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -901,7 +901,7 @@ func TestMVCCScan(t *testing.T) {
 		t.Fatal("the value should not be empty")
 	}
 
-	kvs, _, err = MVCCScan(engine, testKey2, testKey4, 0, makeTS(4, 0), true, nil)
+	kvs, _, _, err = MVCCScan(engine, testKey2, testKey4, 0, makeTS(4, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -913,7 +913,7 @@ func TestMVCCScan(t *testing.T) {
 		t.Fatal("the value should not be empty")
 	}
 
-	kvs, _, err = MVCCScan(engine, testKey4, roachpb.KeyMax, 0, makeTS(1, 0), true, nil)
+	kvs, _, _, err = MVCCScan(engine, testKey4, roachpb.KeyMax, 0, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -924,7 +924,7 @@ func TestMVCCScan(t *testing.T) {
 	}
 
 	_, _, err = MVCCGet(engine, testKey1, makeTS(1, 0), true, txn2)
-	kvs, _, err = MVCCScan(engine, roachpb.KeyMin, testKey2, 0, makeTS(1, 0), true, nil)
+	kvs, _, _, err = MVCCScan(engine, roachpb.KeyMin, testKey2, 0, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -946,7 +946,7 @@ func TestMVCCScanMaxNum(t *testing.T) {
 	err = MVCCPut(engine, nil, testKey3, makeTS(1, 0), value3, nil)
 	err = MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil)
 
-	kvs, _, err := MVCCScan(engine, testKey2, testKey4, 1, makeTS(1, 0), true, nil)
+	kvs, _, _, err := MVCCScan(engine, testKey2, testKey4, 1, makeTS(1, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -957,6 +957,52 @@ func TestMVCCScanMaxNum(t *testing.T) {
 	}
 }
 
+// TestMVCCScanResumeKey verifies that MVCCScan returns a resume key only
+// when max truncated the results, and that it allows the scan to be
+// continued where it left off.
+func TestMVCCScanResumeKey(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	stopper := stop.NewStopper()
+	defer stopper.Stop()
+	engine := createTestEngine(stopper)
+
+	for _, key := range []roachpb.Key{testKey1, testKey2, testKey3, testKey4} {
+		if err := MVCCPut(engine, nil, key, makeTS(1, 0), value1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kvs, resumeKey, _, err := MVCCScan(engine, testKey1, testKey4, 0, makeTS(1, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 3 || resumeKey != nil {
+		t.Fatalf("expected 3 results and no resume key; got %v, %q", kvs, resumeKey)
+	}
+	kvs, resumeKey, _, err = MVCCScan(engine, testKey1, testKey4, 4, makeTS(1, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 3 || resumeKey != nil {
+		t.Fatalf("expected 3 results and no resume key; got %v, %q", kvs, resumeKey)
+	}
+
+	kvs, resumeKey, _, err = MVCCScan(engine, testKey1, testKey4, 2, makeTS(1, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 || !bytes.Equal(kvs[1].Key, testKey2) || !resumeKey.Equal(testKey2.Next()) {
+		t.Fatalf("expected 2 results and resume key %q; got %v, %q", testKey2.Next(), kvs, resumeKey)
+	}
+	kvs, resumeKey, _, err = MVCCScan(engine, resumeKey, testKey4, 2, makeTS(1, 0), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || !bytes.Equal(kvs[0].Key, testKey3) || resumeKey != nil {
+		t.Fatalf("expected %q and no resume key; got %v, %q", testKey3, kvs, resumeKey)
+	}
+}
+
 func TestMVCCScanWithKeyPrefix(t *testing.T) {
 	defer leaktest.AfterTest(t)
 	stopper := stop.NewStopper()
@@ -980,7 +1026,7 @@ func TestMVCCScanWithKeyPrefix(t *testing.T) {
 	err = MVCCPut(engine, nil, roachpb.Key("/aa"), makeTS(3, 0), value3, nil)
 	err = MVCCPut(engine, nil, roachpb.Key("/b"), makeTS(1, 0), value3, nil)
 
-	kvs, _, err := MVCCScan(engine, roachpb.Key("/a"), roachpb.Key("/b"), 0, makeTS(2, 0), true, nil)
+	kvs, _, _, err := MVCCScan(engine, roachpb.Key("/a"), roachpb.Key("/b"), 0, makeTS(2, 0), true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1004,7 +1050,7 @@ func TestMVCCScanInTxn(t *testing.T) {
 	err = MVCCPut(engine, nil, testKey3, makeTS(1, 0), value3, txn1)
 	err = MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil)
 
-	kvs, _, err := MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, txn1)
+	kvs, _, _, err := MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, txn1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1016,7 +1062,7 @@ func TestMVCCScanInTxn(t *testing.T) {
 		t.Fatal("the value should not be empty")
 	}
 
-	kvs, _, err = MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, nil)
+	kvs, _, _, err = MVCCScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, nil)
 	if err == nil {
 		t.Fatal("expected error on uncommitted write intent")
 	}
@@ -1031,7 +1077,7 @@ func TestMVCCScanInconsistent(t *testing.T) {
 	engine := createTestEngine(stopper)
 
 	// A scan with consistent=false should fail in a txn.
-	if _, _, err := MVCCScan(engine, roachpb.KeyMin, roachpb.KeyMax, 0, makeTS(1, 0), false, txn1); err == nil {
+	if _, _, _, err := MVCCScan(engine, roachpb.KeyMin, roachpb.KeyMax, 0, makeTS(1, 0), false, txn1); err == nil {
 		t.Error("expected an error scanning with consistent=false in txn")
 	}
 
@@ -1064,7 +1110,7 @@ func TestMVCCScanInconsistent(t *testing.T) {
 		{Key: testKey1, Txn: *txn1},
 		{Key: testKey3, Txn: *txn2},
 	}
-	kvs, intents, err := MVCCScan(engine, testKey1, testKey4.Next(), 0, makeTS(7, 0), false, nil)
+	kvs, _, intents, err := MVCCScan(engine, testKey1, testKey4.Next(), 0, makeTS(7, 0), false, nil)
 	if !reflect.DeepEqual(intents, expIntents) {
 		t.Fatal(err)
 	}
@@ -1080,7 +1126,7 @@ func TestMVCCScanInconsistent(t *testing.T) {
 
 	// Now try a scan at a historical timestamp.
 	expIntents = expIntents[:1]
-	kvs, intents, err = MVCCScan(engine, testKey1, testKey4.Next(), 0, makeTS(3, 0), false, nil)
+	kvs, _, intents, err = MVCCScan(engine, testKey1, testKey4.Next(), 0, makeTS(3, 0), false, nil)
 	if !reflect.DeepEqual(intents, expIntents) {
 		t.Fatal(err)
 	}
@@ -1111,7 +1157,7 @@ func TestMVCCDeleteRange(t *testing.T) {
 	if num != 2 {
 		t.Fatal("the value should not be empty")
 	}
-	kvs, _, _ := MVCCScan(engine, roachpb.KeyMin, roachpb.KeyMax, 0, makeTS(2, 0), true, nil)
+	kvs, _, _, _ := MVCCScan(engine, roachpb.KeyMin, roachpb.KeyMax, 0, makeTS(2, 0), true, nil)
 	if len(kvs) != 2 ||
 		!bytes.Equal(kvs[0].Key, testKey1) ||
 		!bytes.Equal(kvs[1].Key, testKey4) ||
@@ -1127,7 +1173,7 @@ func TestMVCCDeleteRange(t *testing.T) {
 	if num != 1 {
 		t.Fatal("the value should not be empty")
 	}
-	kvs, _, _ = MVCCScan(engine, roachpb.KeyMin, roachpb.KeyMax, 0, makeTS(2, 0), true, nil)
+	kvs, _, _, _ = MVCCScan(engine, roachpb.KeyMin, roachpb.KeyMax, 0, makeTS(2, 0), true, nil)
 	if len(kvs) != 1 ||
 		!bytes.Equal(kvs[0].Key, testKey1) ||
 		!bytes.Equal(kvs[0].Value.Bytes, value1.Bytes) {
@@ -1141,7 +1187,7 @@ func TestMVCCDeleteRange(t *testing.T) {
 	if num != 1 {
 		t.Fatal("the value should not be empty")
 	}
-	kvs, _, _ = MVCCScan(engine, roachpb.KeyMin, roachpb.KeyMax, 0, makeTS(2, 0), true, nil)
+	kvs, _, _, _ = MVCCScan(engine, roachpb.KeyMin, roachpb.KeyMax, 0, makeTS(2, 0), true, nil)
 	if len(kvs) != 0 {
 		t.Fatal("the value should be empty")
 	}
@@ -1315,6 +1361,99 @@ func TestMVCCReverseScan(t *testing.T) {
 	}
 }
 
+// TestMVCCReverseScanMaxNum verifies that MVCCReverseScan truncates its
+// results from the high end of the range and returns the newest version
+// of each key which is visible at the scan timestamp.
+func TestMVCCReverseScanMaxNum(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	stopper := stop.NewStopper()
+	defer stopper.Stop()
+	engine := createTestEngine(stopper)
+
+	for _, kv := range []struct {
+		key   roachpb.Key
+		ts    roachpb.Timestamp
+		value roachpb.Value
+	}{
+		{testKey1, makeTS(1, 0), value1},
+		{testKey2, makeTS(1, 0), value2},
+		{testKey3, makeTS(1, 0), value3},
+		{testKey3, makeTS(2, 0), value1},
+		{testKey4, makeTS(1, 0), value4},
+	} {
+		if err := MVCCPut(engine, nil, kv.key, kv.ts, kv.value, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		max      int64
+		ts       roachpb.Timestamp
+		expKeys  []roachpb.Key
+		expValue []roachpb.Value
+	}{
+		{1, makeTS(1, 0), []roachpb.Key{testKey3}, []roachpb.Value{value3}},
+		{2, makeTS(1, 0), []roachpb.Key{testKey3, testKey2}, []roachpb.Value{value3, value2}},
+		{2, makeTS(2, 0), []roachpb.Key{testKey3, testKey2}, []roachpb.Value{value1, value2}},
+		{0, makeTS(2, 0), []roachpb.Key{testKey3, testKey2, testKey1}, []roachpb.Value{value1, value2, value1}},
+	}
+	for i, test := range testCases {
+		kvs, _, err := MVCCReverseScan(engine, testKey1, testKey4, test.max, test.ts, true, nil)
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+		if len(kvs) != len(test.expKeys) {
+			t.Fatalf("%d: expected %d results; got %v", i, len(test.expKeys), kvs)
+		}
+		for j, kv := range kvs {
+			if !bytes.Equal(kv.Key, test.expKeys[j]) || !bytes.Equal(kv.Value.Bytes, test.expValue[j].Bytes) {
+				t.Errorf("%d: expected %s=%q at index %d; got %s=%q",
+					i, test.expKeys[j], test.expValue[j].Bytes, j, kv.Key, kv.Value.Bytes)
+			}
+		}
+	}
+}
+
+// TestMVCCReverseScanInTxn verifies that MVCCReverseScan sees the
+// transaction's own intents and fails on those of other transactions.
+func TestMVCCReverseScanInTxn(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	stopper := stop.NewStopper()
+	defer stopper.Stop()
+	engine := createTestEngine(stopper)
+
+	if err := MVCCPut(engine, nil, testKey1, makeTS(1, 0), value1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCPut(engine, nil, testKey2, makeTS(1, 0), value2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCPut(engine, nil, testKey3, makeTS(1, 0), value3, txn1); err != nil {
+		t.Fatal(err)
+	}
+	if err := MVCCPut(engine, nil, testKey4, makeTS(1, 0), value4, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	kvs, _, err := MVCCReverseScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, txn1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 ||
+		!bytes.Equal(kvs[0].Key, testKey3) ||
+		!bytes.Equal(kvs[1].Key, testKey2) ||
+		!bytes.Equal(kvs[0].Value.Bytes, value3.Bytes) ||
+		!bytes.Equal(kvs[1].Value.Bytes, value2.Bytes) {
+		t.Errorf("unexpected value: %v", kvs)
+	}
+
+	if _, _, err := MVCCReverseScan(engine, testKey2, testKey4, 0, makeTS(1, 0), true, nil); err == nil {
+		t.Fatal("expected error on uncommitted write intent")
+	} else if _, ok := err.(*roachpb.WriteIntentError); !ok {
+		t.Fatalf("expected WriteIntentError; got %s", err)
+	}
+}
+
 func TestMVCCResolveTxn(t *testing.T) {
 	defer leaktest.AfterTest(t)
 	stopper := stop.NewStopper()
@@ -600,8 +600,8 @@ func (r *Replica) checkBatchRequest(ba roachpb.BatchRequest) error {
 	for i := range ba.Requests {
 		args := ba.Requests[i].GetInner()
 		header := args.Header()
-		if args.Method() == roachpb.EndTransaction && len(ba.Requests) != 1 {
-			return util.Errorf("cannot mix EndTransaction with other operations in a batch")
+		if args.Method() == roachpb.EndTransaction && i != len(ba.Requests)-1 {
+			return util.Errorf("EndTransaction must be the last operation in a batch")
 		}
 		// TODO(tschottdorf): disabled since execution forces at least the batch
 		// timestamp anyways, and this leads to errors on retries.
@@ -1128,6 +1128,12 @@ func (r *Replica) executeBatch(batch engine.Engine, ms *engine.MVCCStats, ba *ro
 	// Have to discuss how we go about it.
 	fiddleWithTimestamps := ba.Txn == nil && ba.IsWrite()
 
+	// A transaction which writes and commits in this very batch never had
+	// its record or intents visible to anyone else, so its record can be
+	// removed right away if all of its intents get resolved synchronously.
+	onePhaseCommit := isOnePhaseCommit(ba)
+	var externalIntents []roachpb.Intent
+
 	// TODO(tschottdorf): provisionals ahead. This loop needs to execute each
 	// command and propagate txn and timestamp to the next (and, eventually,
 	// to the batch response header). We're currently in an intermediate stage
@@ -1188,6 +1194,10 @@ func (r *Replica) executeBatch(batch engine.Engine, ms *engine.MVCCStats, ba *ro
 			*args.Header() = origHeader
 		}
 
+		if args.Method() == roachpb.EndTransaction {
+			externalIntents = curIntents
+		}
+
 		// Collect intents skipped over the course of execution.
 		if len(curIntents) > 0 {
 			// TODO(tschottdorf): see about refactoring the args away.
@@ -1217,6 +1227,12 @@ func (r *Replica) executeBatch(batch engine.Engine, ms *engine.MVCCStats, ba *ro
 			ba.Txn.Timestamp.Forward(br.Timestamp)
 		}
 	}
+	if onePhaseCommit && len(externalIntents) == 0 {
+		if err := engine.MVCCDelete(batch, ms, keys.TransactionKey(ba.Txn.Key, ba.Txn.ID),
+			roachpb.ZeroTimestamp, nil /* txn */); err != nil {
+			return nil, intents, err
+		}
+	}
 	// If transactional, send out the final transaction entry with the reply.
 	if isTxn {
 		br.Txn = ba.Txn
@@ -1224,6 +1240,32 @@ func (r *Replica) executeBatch(batch engine.Engine, ms *engine.MVCCStats, ba *ro
 	return br, intents, nil
 }
 
+// isOnePhaseCommit returns true iff the batch begins and commits a
+// transaction: the transaction hasn't written before, and the batch
+// carries its writes along with a committing EndTransaction. Batches
+// with a commit trigger are excluded since the trigger may have moved
+// the transaction record to another range.
+func isOnePhaseCommit(ba *roachpb.BatchRequest) bool {
+	if ba.Txn == nil || ba.Txn.Writing {
+		return false
+	}
+	arg, ok := ba.GetArg(roachpb.EndTransaction)
+	if !ok {
+		return false
+	}
+	etArgs := arg.(*roachpb.EndTransactionRequest)
+	if !etArgs.Commit || etArgs.InternalCommitTrigger != nil {
+		return false
+	}
+	for _, union := range ba.Requests {
+		if args := union.GetInner(); args.Method() != roachpb.EndTransaction &&
+			roachpb.IsTransactionWrite(args) {
+			return true
+		}
+	}
+	return false
+}
+
 // getLeaseForGossip tries to obtain a leader lease. Only one of the replicas
 // should gossip; the bool returned indicates whether it's us.
 func (r *Replica) getLeaseForGossip(ctx context.Context) (bool, error) {
@@ -1555,7 +1597,7 @@ func (r *Replica) resolveIntents(ctx context.Context, intents []roachpb.Intent)
 // key/value pairs along with the sha1 checksum of the contents (key and value).
 func loadSystemDBSpan(eng engine.Engine) ([]roachpb.KeyValue, []byte, error) {
 	// TODO(tschottdorf): Currently this does not handle intents well.
-	kvs, _, err := engine.MVCCScan(eng, keys.SystemDBSpan.Start, keys.SystemDBSpan.End,
+	kvs, _, _, err := engine.MVCCScan(eng, keys.SystemDBSpan.Start, keys.SystemDBSpan.End,
 		0, roachpb.MaxTimestamp, true /* consistent */, nil)
 	if err != nil {
 		return nil, nil, err
@@ -231,7 +231,7 @@ func (r *Replica) DeleteRange(batch engine.Engine, ms *engine.MVCCStats, ts roac
 func (r *Replica) Scan(batch engine.Engine, ts roachpb.Timestamp, args roachpb.ScanRequest) (roachpb.ScanResponse, []roachpb.Intent, error) {
 	var reply roachpb.ScanResponse
 
-	rows, intents, err := engine.MVCCScan(batch, args.Key, args.EndKey, args.MaxResults, ts, args.ReadConsistency == roachpb.CONSISTENT, args.Txn)
+	rows, _, intents, err := engine.MVCCScan(batch, args.Key, args.EndKey, args.MaxResults, ts, args.ReadConsistency == roachpb.CONSISTENT, args.Txn)
 	reply.Rows = rows
 	return reply, intents, err
 }
@@ -566,7 +566,7 @@ func (r *Replica) RangeLookup(batch engine.Engine, ts roachpb.Timestamp, args ro
 		}
 
 		// Scan for descriptors.
-		kvs, intents, err = engine.MVCCScan(batch, startKey, endKey, rangeCount,
+		kvs, _, intents, err = engine.MVCCScan(batch, startKey, endKey, rangeCount,
 			ts, consistent, args.Txn)
 		if err != nil {
 			// An error here is likely a WriteIntentError when reading consistently.
@@ -614,7 +614,7 @@ func (r *Replica) RangeLookup(batch engine.Engine, ts roachpb.Timestamp, args ro
 				return reply, nil, err
 			}
 
-			kvs, intents, err = engine.MVCCScan(batch, startKey, endKey, 1,
+			kvs, _, intents, err = engine.MVCCScan(batch, startKey, endKey, 1,
 				ts, consistent, args.Txn)
 			if err != nil {
 				return reply, nil, err
@@ -1150,7 +1150,7 @@ func (r *Replica) AdminSplit(args roachpb.AdminSplitRequest, desc *roachpb.Range
 			return err
 		}
 		// Update range descriptor addressing record(s).
-		if err := splitRangeAddressing(b, newDesc, &updatedDesc); err != nil {
+		if err := splitRangeAddressing(b, &updatedDesc, newDesc); err != nil {
 			return err
 		}
 		if err := txn.Run(b); err != nil {
@@ -1340,7 +1340,7 @@ func (r *Replica) AdminMerge(args roachpb.AdminMergeRequest, origLeftDesc *roach
 		// Remove the range descriptor for the deleted range.
 		b.Del(rightDescKey)
 
-		if err := mergeRangeAddressing(b, origLeftDesc, &updatedLeftDesc); err != nil {
+		if err := mergeRangeAddressing(b, &updatedLeftDesc, []*roachpb.RangeDescriptor{origLeftDesc, &rightDesc}); err != nil {
 			return err
 		}
 
@@ -1910,6 +1910,59 @@ func TestEndTransactionResolveOnlyLocalIntents(t *testing.T) {
 	}
 }
 
+// TestEndTransactionOnePhaseCommit verifies that the record of a
+// transaction which writes and commits in a single batch is removed
+// right away, while it is kept when the writes were sent earlier.
+func TestEndTransactionOnePhaseCommit(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	defer setTxnAutoGC(false)()
+	tc := testContext{}
+	tc.Start(t)
+	defer tc.Stop()
+
+	for i, onePhase := range []bool{true, false} {
+		key := roachpb.Key(fmt.Sprintf("a%d", i))
+		txn := newTransaction("test", key, 1, roachpb.SERIALIZABLE, tc.clock)
+		pArgs := putArgs(key, []byte("value"), 1, tc.store.StoreID())
+		etArgs := endTxnArgs(txn, true /* commit */, 1, tc.store.StoreID())
+		etArgs.Intents = []roachpb.Intent{{Key: key}}
+
+		ba := roachpb.BatchRequest{}
+		ba.RangeID = tc.rng.Desc().RangeID
+		ba.Replica.StoreID = tc.store.StoreID()
+		ba.Txn = txn
+		ba.Add(&pArgs)
+		if !onePhase {
+			if _, pErr := tc.rng.Send(tc.rng.context(), ba); pErr != nil {
+				t.Fatal(pErr)
+			}
+			ba.Requests = nil
+			ba.Txn.Writing = true
+		}
+		ba.Add(&etArgs)
+		if _, pErr := tc.rng.Send(tc.rng.context(), ba); pErr != nil {
+			t.Fatal(pErr)
+		}
+
+		// The write must be visible either way.
+		gArgs := getArgs(key, 1, tc.store.StoreID())
+		if _, err := client.SendWrapped(tc.rng, tc.rng.context(), &gArgs); err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		var readTxn roachpb.Transaction
+		txnKey := keys.TransactionKey(txn.Key, txn.ID)
+		ok, err := engine.MVCCGetProto(tc.rng.rm.Engine(), txnKey, roachpb.ZeroTimestamp,
+			true /* consistent */, nil /* txn */, &readTxn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok == onePhase {
+			t.Errorf("%d: expected transaction record to exist: %t, got %t", i, !onePhase, ok)
+		}
+	}
+}
+
 // TestPushTxnBadKey verifies that args.Key equals args.PusheeTxn.ID.
 func TestPushTxnBadKey(t *testing.T) {
 	defer leaktest.AfterTest(t)
@@ -20,6 +20,7 @@ package storage
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -265,6 +266,9 @@ type Store struct {
 	feed              StoreEventFeed  // Event Feed
 	removeReplicaChan chan removeReplicaOp
 	proposeChan       chan proposeOp
+	txnWaits          *txnWaitGraph   // Waits-for edges between conflicting txns
+	admission         *admissionQueue // Limits concurrently executing requests
+	watches           *watchRegistry  // Watchers of committed updates
 	multiraft         *multiraft.MultiRaft
 	started           int32
 	stopper           *stop.Stopper
@@ -278,6 +282,7 @@ type Store struct {
 }
 
 var _ client.Sender = &Store{}
+var _ client.Watcher = &Store{}
 var _ multiraft.Storage = &Store{}
 
 // A StoreContext encompasses the auxiliary objects and configuration
@@ -329,6 +334,33 @@ type StoreContext struct {
 
 	// Tracer is a request tracer.
 	Tracer *tracer.Tracer
+
+	// OnContention, if set, is invoked whenever a request backs off on an
+	// intent which could not be resolved. ours is the transaction of the
+	// waiting request (nil if non-transactional) and theirs the
+	// transaction owning the intent on key.
+	OnContention func(ours, theirs *roachpb.Transaction, key roachpb.Key)
+
+	// IntentResolutionDeadline, if non-zero, bounds the time a request
+	// backs off on an intent it failed to resolve. Once a request has
+	// been waiting on a key for longer than this, the conflicting
+	// transaction is pushed before the request is retried: it's aborted
+	// if the pusher's priority is higher, and otherwise a read pushes its
+	// timestamp regardless of priority, which lets the read through
+	// without aborting the higher-priority transaction. A write with the
+	// lower priority keeps backing off. See deadlinePush.
+	IntentResolutionDeadline time.Duration
+
+	// MaxConcurrentRequests, if non-zero, limits the number of requests
+	// executing on the store at the same time. Further requests wait to
+	// be admitted in order of their user priority, then their
+	// transaction's priority.
+	MaxConcurrentRequests int
+
+	// MaxQueuedRequests, if non-zero, limits the number of requests
+	// waiting for admission. Once it's reached, the lowest-priority
+	// request is turned away with a retryable error.
+	MaxQueuedRequests int
 }
 
 // Valid returns true if the StoreContext is populated correctly.
@@ -377,6 +409,9 @@ func NewStore(ctx StoreContext, eng engine.Engine, nodeDesc *roachpb.NodeDescrip
 		nodeDesc:          nodeDesc,
 		removeReplicaChan: make(chan removeReplicaOp),
 		proposeChan:       make(chan proposeOp),
+		txnWaits:          newTxnWaitGraph(),
+		admission:         newAdmissionQueue(ctx.MaxConcurrentRequests, ctx.MaxQueuedRequests),
+		watches:           newWatchRegistry(),
 	}
 
 	// Add range scanner and configure with queues.
@@ -391,6 +426,12 @@ func NewStore(ctx StoreContext, eng engine.Engine, nodeDesc *roachpb.NodeDescrip
 	return s
 }
 
+// AdmissionMetrics returns a snapshot of the state of the store's
+// admission queue. See StoreContext.MaxConcurrentRequests.
+func (s *Store) AdmissionMetrics() AdmissionMetrics {
+	return s.admission.metrics()
+}
+
 // String formats a store for debug output.
 func (s *Store) String() string {
 	return fmt.Sprintf("store=%d:%d (%s)", s.Ident.NodeID, s.Ident.StoreID, s.engine)
@@ -1199,6 +1240,20 @@ func (s *Store) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.Bat
 	}
 	var rng *Replica
 	var err error
+	// waitingSince holds the time at which this request first backed off
+	// on each conflicting key. See IntentResolutionDeadline.
+	var waitingSince map[string]time.Time
+	// deadlocked is set when this request's transaction has been found
+	// to be part of a cycle of waiting transactions which it is allowed
+	// to break by pushing its way through.
+	var deadlocked bool
+	var txnPriority int32
+	var waiter int64
+	if ba.Txn != nil {
+		waiter = s.txnWaits.newWaiter()
+		defer s.txnWaits.done(string(ba.Txn.ID), waiter)
+		txnPriority = ba.Txn.Priority
+	}
 
 	// Add the command to the range for execution; exit retry loop on success.
 	for r := retry.Start(s.ctx.RangeRetryOptions); next(&r); {
@@ -1210,12 +1265,21 @@ func (s *Store) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.Bat
 
 		var br *roachpb.BatchResponse
 		{
+			// Only hold an admission slot while executing, not while
+			// backing off or resolving intents below.
+			if err := s.admission.admit(ctx, ba.GetUserPriority(), txnPriority); err != nil {
+				return nil, roachpb.NewError(err)
+			}
 			var pErr *roachpb.Error
 			br, pErr = rng.Send(ctx, ba)
+			s.admission.release()
 			err = pErr.GoError()
 		}
 
 		if err == nil {
+			if ba.IsWrite() {
+				s.publishWatchEvents(rng, &ba, br)
+			}
 			return br, nil
 		}
 
@@ -1224,6 +1288,17 @@ func (s *Store) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.Bat
 		// waiting. We don't want every replica to attempt to resolve the
 		// intent independently, so we can't do it there.
 		if wiErr, ok := err.(*roachpb.WriteIntentError); ok {
+			// A transaction never conflicts with its own intents, whatever
+			// their epoch. If it appears to, something is badly wrong; don't
+			// try to push ourselves, which would back off indefinitely.
+			if ba.Txn != nil {
+				for _, intent := range wiErr.Intents {
+					if roachpb.TxnIDEqual(intent.Txn.ID, ba.Txn.ID) {
+						return nil, roachpb.NewError(util.Errorf("txn %s conflicts with its own intent on key %s",
+							ba.Txn.Short(), intent.Key))
+					}
+				}
+			}
 			var pushType roachpb.PushTxnType
 			if ba.IsWrite() {
 				pushType = roachpb.ABORT_TXN
@@ -1234,6 +1309,14 @@ func (s *Store) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.Bat
 			index, ok := wiErr.ErrorIndex()
 			if ok {
 				args := ba.Requests[index].GetInner()
+				if deadlocked {
+					trace.Event("breaking txn deadlock")
+					s.refreshPriorities(wiErr.Intents)
+					args = escalatePushPriority(args, wiErr.Intents)
+				} else if s.intentDeadlineExceeded(waitingSince, wiErr.Intents) {
+					trace.Event("intent resolution deadline exceeded")
+					pushType, args = deadlinePush(args, pushType, wiErr.Intents)
+				}
 				// TODO(tschottdorf): implications of using the batch ts here?
 				err = s.resolveWriteIntentError(ctx, wiErr, rng, args, ba.Timestamp, pushType)
 				// Make sure that if an index is carried in the error, it
@@ -1269,12 +1352,55 @@ func (s *Store) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.Bat
 				}
 				continue
 			}
-
 			// Otherwise, update timestamp on read/write and backoff / retry.
-			for _, intent := range t.Intents {
+			for i := range t.Intents {
+				intent := &t.Intents[i]
 				if ba.IsWrite() && ba.Timestamp.Less(intent.Txn.Timestamp) {
 					ba.Timestamp = intent.Txn.Timestamp.Next()
 				}
+				if log.V(1) {
+					log.Infoc(ctx, "txn %s waiting on txn %s for intent on key %s (attempt %d)",
+						ba.Txn.Short(), intent.Txn.Short(), intent.Key, r.CurrentAttempt())
+				}
+				if s.ctx.OnContention != nil {
+					s.ctx.OnContention(ba.Txn, &intent.Txn, intent.Key)
+				}
+				if s.ctx.IntentResolutionDeadline > 0 {
+					if waitingSince == nil {
+						waitingSince = map[string]time.Time{}
+					}
+					if _, ok := waitingSince[string(intent.Key)]; !ok {
+						waitingSince[string(intent.Key)] = time.Now()
+					}
+				}
+			}
+			// Record what we're waiting on. If that closes a cycle, the
+			// transactions involved would otherwise keep pushing each other
+			// in vain until their priorities happen to sort it out: abort
+			// right away if we lose to one of our pushees and force our
+			// way through on the next attempt otherwise. Only waits on
+			// this store are seen, so a cycle through another store goes
+			// undetected (see txnWaitGraph).
+			if ba.Txn != nil {
+				pushees := make([]string, len(t.Intents))
+				for i := range t.Intents {
+					pushees[i] = string(t.Intents[i].Txn.ID)
+				}
+				if s.txnWaits.wait(string(ba.Txn.ID), waiter, pushees) {
+					trace.Event("txn deadlock detected")
+					// Pushes may have raised the priorities since the intents
+					// were written and our txn was sent; decide on the ones
+					// in the transaction records.
+					ours := *ba.Txn
+					ours.Priority = s.recordedPriority(ba.Txn)
+					s.refreshPriorities(t.Intents)
+					for i := range t.Intents {
+						if losesDeadlock(&ours, &t.Intents[i].Txn) {
+							return nil, roachpb.NewError(roachpb.NewTransactionAbortedError(ba.Txn))
+						}
+					}
+					deadlocked = true
+				}
 			}
 			if log.V(1) {
 				log.Warning(err)
@@ -1294,6 +1420,118 @@ func (s *Store) Send(ctx context.Context, ba roachpb.BatchRequest) (*roachpb.Bat
 	return nil, roachpb.NewError(err)
 }
 
+// intentDeadlineExceeded returns true if the store's intent resolution
+// deadline is set and the request has been backing off on the key of
+// any of the given intents for longer than that.
+func (s *Store) intentDeadlineExceeded(waitingSince map[string]time.Time, intents []roachpb.Intent) bool {
+	if s.ctx.IntentResolutionDeadline <= 0 {
+		return false
+	}
+	for _, intent := range intents {
+		if since, ok := waitingSince[string(intent.Key)]; ok && time.Since(since) >= s.ctx.IntentResolutionDeadline {
+			return true
+		}
+	}
+	return false
+}
+
+// losesDeadlock returns true if txn is the one to be aborted to break a
+// deadlock with other: the transaction with the lower priority loses,
+// with ties broken by transaction ID.
+func losesDeadlock(txn, other *roachpb.Transaction) bool {
+	if txn.Priority != other.Priority {
+		return txn.Priority < other.Priority
+	}
+	return bytes.Compare(txn.ID, other.ID) < 0
+}
+
+// deadlinePush returns the push type and arguments with which a request
+// that has exceeded the intent resolution deadline pushes the
+// transactions owning the given intents. If the pusher's priority
+// exceeds theirs, they are aborted. Otherwise a read forces their
+// timestamps past its own, which lets it through while leaving them
+// running; a write can only get through by aborting them, so it keeps
+// pushing with its real priority.
+func deadlinePush(args roachpb.Request, pushType roachpb.PushTxnType, intents []roachpb.Intent) (roachpb.PushTxnType, roachpb.Request) {
+	if outranks(args.Header().Txn, intents) {
+		return roachpb.ABORT_TXN, args
+	}
+	if roachpb.IsReadOnly(args) {
+		return roachpb.PUSH_TIMESTAMP, escalatePushPriority(args, intents)
+	}
+	return pushType, args
+}
+
+// outranks returns true if txn's priority exceeds that of every pending
+// transaction among the given intents. Non-transactional requests don't
+// outrank anyone.
+func outranks(txn *roachpb.Transaction, intents []roachpb.Intent) bool {
+	if txn == nil {
+		return false
+	}
+	for _, intent := range intents {
+		if intent.Txn.Status == roachpb.PENDING && intent.Txn.Priority >= txn.Priority {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshPriorities replaces the priorities of the pending
+// transactions among the given intents with those in their transaction
+// records (see recordedPriority).
+func (s *Store) refreshPriorities(intents []roachpb.Intent) {
+	for i := range intents {
+		if txn := &intents[i].Txn; txn.Status == roachpb.PENDING {
+			txn.Priority = s.recordedPriority(txn)
+		}
+	}
+}
+
+// recordedPriority returns the priority in txn's transaction record,
+// which pushes raise to just below the pusher's, or txn's own priority
+// if that's higher or the record can't be read.
+func (s *Store) recordedPriority(txn *roachpb.Transaction) int32 {
+	var record roachpb.Transaction
+	if err := s.db.GetProto(keys.TransactionKey(txn.Key, txn.ID), &record); err != nil {
+		if log.V(1) {
+			log.Warningf("failed to read txn record of %s: %s", txn.Short(), err)
+		}
+		return txn.Priority
+	}
+	if record.Priority < txn.Priority {
+		return txn.Priority
+	}
+	return record.Priority
+}
+
+// escalatePushPriority returns a copy of args whose transaction (or,
+// for non-transactional requests, a stand-in pusher transaction)
+// carries a priority exceeding that of every pending transaction among
+// the given intents, so that pushing them is guaranteed to succeed.
+// It's used to break deadlocks, in which the pusher has already been
+// found to win against its pushees (see losesDeadlock), and to push
+// timestamps past the intent resolution deadline (see deadlinePush).
+func escalatePushPriority(args roachpb.Request, intents []roachpb.Intent) roachpb.Request {
+	args = proto.Clone(args).(roachpb.Request)
+	header := args.Header()
+	if header.Txn == nil {
+		header.Txn = &roachpb.Transaction{
+			Priority: roachpb.MakePriority(header.GetUserPriority()),
+		}
+	}
+	for _, intent := range intents {
+		if intent.Txn.Status == roachpb.PENDING && intent.Txn.Priority >= header.Txn.Priority {
+			if intent.Txn.Priority == math.MaxInt32 {
+				header.Txn.Priority = math.MaxInt32
+			} else {
+				header.Txn.Priority = intent.Txn.Priority + 1
+			}
+		}
+	}
+	return args
+}
+
 // resolveWriteIntentError tries to push the conflicting transaction (if
 // necessary, i.e. if the transaction is pending): either move its timestamp
 // forward on a read/write conflict, or abort it on a write/write conflict. If
@@ -953,6 +953,229 @@ func TestStoreResolveWriteIntentPushOnRead(t *testing.T) {
 	}
 }
 
+// TestStoreOnContention verifies that the OnContention callback is
+// invoked with both transactions and the key when a read backs off on
+// an intent it failed to push.
+func TestStoreOnContention(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	setTestRetryOptions(store)
+
+	key := roachpb.Key("a")
+	pusher := newTransaction("test", key, 1, roachpb.SERIALIZABLE, store.ctx.Clock)
+	pushee := newTransaction("test", key, 1, roachpb.SERIALIZABLE, store.ctx.Clock)
+	pushee.Priority = 2
+	pusher.Priority = 1 // Pusher will lose.
+
+	var contended []roachpb.Key
+	store.ctx.OnContention = func(ours, theirs *roachpb.Transaction, key roachpb.Key) {
+		if !bytes.Equal(ours.ID, pusher.ID) {
+			t.Errorf("expected our txn to be the pusher; got %s", ours)
+		}
+		if !bytes.Equal(theirs.ID, pushee.ID) {
+			t.Errorf("expected their txn to be the pushee; got %s", theirs)
+		}
+		contended = append(contended, key)
+	}
+
+	args := putArgs(key, []byte("value"), 1, store.StoreID())
+	args.Txn = pushee
+	if _, err := client.SendWrapped(store, nil, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	gArgs := getArgs(key, 1, store.StoreID())
+	gArgs.Txn = pusher
+	if _, err := client.SendWrapped(store, nil, &gArgs); err == nil {
+		t.Fatal("expected read to fail")
+	}
+
+	if len(contended) == 0 {
+		t.Fatal("expected contention callback to be invoked")
+	}
+	for _, k := range contended {
+		if !k.Equal(key) {
+			t.Errorf("expected contention on key %s; got %s", key, k)
+		}
+	}
+}
+
+// TestStoreIntentResolutionDeadline verifies that a request which
+// keeps losing a push against a conflicting transaction that never
+// resolves on its own backs off only until the store's intent
+// resolution deadline has passed. A read then pushes the transaction's
+// timestamp and succeeds, without aborting the higher-priority pushee;
+// a write keeps backing off rather than aborting it.
+func TestStoreIntentResolutionDeadline(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	setTestRetryOptions(store)
+
+	for i, write := range []bool{false, true} {
+		key := roachpb.Key(fmt.Sprintf("key-%d", i))
+		pusher := newTransaction("test", key, 1, roachpb.SERIALIZABLE, store.ctx.Clock)
+		pushee := newTransaction("test", key, 1, roachpb.SERIALIZABLE, store.ctx.Clock)
+		pushee.Priority = 2
+		pusher.Priority = 1 // Pusher loses on priority.
+
+		args := putArgs(key, []byte("value"), 1, store.StoreID())
+		args.Txn = pushee
+		if _, err := client.SendWrapped(store, nil, &args); err != nil {
+			t.Fatal(err)
+		}
+
+		var req roachpb.Request
+		if write {
+			pArgs := putArgs(key, []byte("value2"), 1, store.StoreID())
+			pArgs.Txn = pusher
+			req = &pArgs
+		} else {
+			gArgs := getArgs(key, 1, store.StoreID())
+			gArgs.Txn = pusher
+			req = &gArgs
+		}
+
+		// Without a deadline, the pusher exhausts its retries.
+		store.ctx.IntentResolutionDeadline = 0
+		if _, err := client.SendWrapped(store, nil, req); err == nil {
+			t.Fatalf("%d: expected request to fail without a deadline", i)
+		}
+
+		store.ctx.IntentResolutionDeadline = time.Nanosecond
+		_, err := client.SendWrapped(store, nil, req)
+		if write {
+			if err == nil {
+				t.Errorf("%d: expected write to fail", i)
+			}
+		} else if err != nil {
+			t.Errorf("%d: expected read to succeed after the deadline; got %s", i, err)
+		}
+		if pusher.Priority != 1 {
+			t.Errorf("%d: expected the pusher's priority to be left alone; got %d", i, pusher.Priority)
+		}
+
+		// The pushee is never aborted by the lower-priority pusher; the read
+		// pushed its timestamp instead.
+		var pusheeRecord roachpb.Transaction
+		ok, err := engine.MVCCGetProto(store.Engine(), keys.TransactionKey(pushee.Key, pushee.ID),
+			roachpb.ZeroTimestamp, true, nil, &pusheeRecord)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if write {
+			if ok && pusheeRecord.Status != roachpb.PENDING {
+				t.Errorf("%d: expected pushee to be left pending; got %s", i, pusheeRecord.Status)
+			}
+		} else if !ok || pusheeRecord.Status != roachpb.PENDING || !pushee.Timestamp.Less(pusheeRecord.Timestamp) {
+			t.Errorf("%d: expected pushee's timestamp to be pushed; got %+v", i, pusheeRecord)
+		}
+	}
+}
+
+// TestStoreTxnDeadlock verifies that a transaction which finds itself
+// in a cycle of waiting transactions is aborted right away if it loses
+// to the transaction it is waiting on, going by the priority in that
+// transaction's record, and forces its way through otherwise.
+func TestStoreTxnDeadlock(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+	setTestRetryOptions(store)
+
+	testCases := []struct {
+		pusherPriority int32
+		pusherID       []byte
+		// If non-zero, the pushee's txn record carries this priority, as
+		// if a push had raised it since the intent was written.
+		recordPriority int32
+		expAbort       bool
+	}{
+		// Lower priority: the pusher is aborted.
+		{1, []byte("pusher"), 0, true},
+		// Same priority, but the pusher wins the tie on ID.
+		{2, []byte("zzz"), 0, false},
+		// Higher priority than the intent, but lower than the record.
+		{3, []byte("zzz"), 4, true},
+	}
+	for i, test := range testCases {
+		key := roachpb.Key(fmt.Sprintf("key-%d", i))
+		pushee := newTransaction("test", key, 1, roachpb.SERIALIZABLE, store.ctx.Clock)
+		pusher := newTransaction("test", key, 1, roachpb.SERIALIZABLE, store.ctx.Clock)
+		pushee.Priority = 2
+		pushee.ID = []byte("pushee")
+		pusher.Priority = test.pusherPriority
+		pusher.ID = test.pusherID
+
+		args := putArgs(key, []byte("value"), 1, store.StoreID())
+		args.Txn = pushee
+		if _, err := client.SendWrapped(store, nil, &args); err != nil {
+			t.Fatal(err)
+		}
+		if test.recordPriority != 0 {
+			record := *pushee
+			record.Priority = test.recordPriority
+			if err := engine.MVCCPutProto(store.Engine(), nil, keys.TransactionKey(pushee.Key, pushee.ID),
+				roachpb.ZeroTimestamp, nil, &record); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Make the pushee wait on the pusher, as if it were blocked on one
+		// of the pusher's intents elsewhere.
+		waiter := store.txnWaits.newWaiter()
+		if store.txnWaits.wait(string(pushee.ID), waiter, []string{string(pusher.ID)}) {
+			t.Fatalf("%d: unexpected cycle", i)
+		}
+
+		gArgs := getArgs(key, 1, store.StoreID())
+		gArgs.Txn = pusher
+		_, err := client.SendWrapped(store, nil, &gArgs)
+		if test.expAbort {
+			if _, ok := err.(*roachpb.TransactionAbortedError); !ok {
+				t.Errorf("%d: expected txn aborted error; got %v", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d: expected read to succeed after breaking the deadlock; got %s", i, err)
+		}
+		store.txnWaits.done(string(pushee.ID), waiter)
+	}
+}
+
+// TestStoreSelfConflict verifies that a transaction which appears to
+// conflict with its own intent fails immediately instead of backing
+// off.
+func TestStoreSelfConflict(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	key := roachpb.Key("a")
+	var attempts int32
+	TestingCommandFilter = func(args roachpb.Request) error {
+		if _, ok := args.(*roachpb.GetRequest); ok && args.Header().Key.Equal(key) && args.Header().Txn != nil {
+			atomic.AddInt32(&attempts, 1)
+			return &roachpb.WriteIntentError{
+				Intents: []roachpb.Intent{{Key: key, Txn: *args.Header().Txn}},
+			}
+		}
+		return nil
+	}
+	defer func() {
+		TestingCommandFilter = nil
+	}()
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	txn := newTransaction("test", key, 1, roachpb.SERIALIZABLE, store.ctx.Clock)
+	gArgs := getArgs(key, 1, store.StoreID())
+	gArgs.Txn = txn
+	if _, err := client.SendWrapped(store, nil, &gArgs); !testutils.IsError(err, "conflicts with its own intent") {
+		t.Fatalf("expected self-conflict error; got %v", err)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("expected a single attempt; got %d", n)
+	}
+}
+
 // TestStoreResolveWriteIntentSnapshotIsolation verifies that the
 // timestamp can always be pushed if txn has snapshot isolation.
 func TestStoreResolveWriteIntentSnapshotIsolation(t *testing.T) {
@@ -1009,6 +1232,48 @@ func TestStoreResolveWriteIntentSnapshotIsolation(t *testing.T) {
 	}
 }
 
+// TestStoreWriteTooOldTxn verifies that a transactional write which
+// finds a more recently committed value is retried by the store just
+// past that value's timestamp, without restarting the transaction.
+func TestStoreWriteTooOldTxn(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, mc, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	key := roachpb.Key("a")
+	txn := newTransaction("test", key, 1, roachpb.SERIALIZABLE, store.ctx.Clock)
+
+	// Write a value more recently than the transaction's timestamp.
+	mc.Increment(100)
+	args := putArgs(key, []byte("value1"), 1, store.StoreID())
+	existingTS := store.ctx.Clock.Now()
+	if _, err := client.SendWrappedAt(store, nil, existingTS, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	args.Txn = txn
+	args.Value.Bytes = []byte("value2")
+	reply, err := client.SendWrappedAt(store, nil, txn.Timestamp, &args)
+	if err != nil {
+		t.Fatalf("expected write to succeed: %s", err)
+	}
+	replyTxn := reply.Header().Txn
+	expTS := existingTS.Next()
+	if replyTxn.Epoch != txn.Epoch {
+		t.Errorf("expected epoch %d; got %d", txn.Epoch, replyTxn.Epoch)
+	}
+	if !replyTxn.Timestamp.Equal(expTS) {
+		t.Errorf("expected txn timestamp %s; got %s", expTS, replyTxn.Timestamp)
+	}
+	value, _, err := engine.MVCCGet(store.Engine(), key, roachpb.MaxTimestamp, true, replyTxn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(value.Bytes, []byte("value2")) || !value.Timestamp.Equal(expTS) {
+		t.Errorf("expected intent %q at %s; got %q at %s", "value2", expTS, value.Bytes, value.Timestamp)
+	}
+}
+
 // TestStoreResolveWriteIntentNoTxn verifies that reads and writes
 // which are not part of a transaction can push intents.
 func TestStoreResolveWriteIntentNoTxn(t *testing.T) {
@@ -1451,3 +1716,46 @@ func TestMaybeRemove(t *testing.T) {
 		t.Errorf("Unexpected removed range %v", removedRng)
 	}
 }
+
+// TestStoreWatch verifies that the store's watchers receive the updates
+// committed to the keys they watch, and that their channel is closed
+// once their context is done.
+func TestStoreWatch(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	store, _, stopper := createTestStore(t)
+	defer stopper.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := store.Watch(ctx, roachpb.Key("a"), roachpb.Key("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The write to "b" is outside of the watched span.
+	pArgs := putArgs(roachpb.Key("b"), []byte("ignored"), 1, store.StoreID())
+	if _, err := client.SendWrapped(store, nil, &pArgs); err != nil {
+		t.Fatal(err)
+	}
+	pArgs = putArgs(roachpb.Key("a"), []byte("value"), 1, store.StoreID())
+	if _, err := client.SendWrapped(store, nil, &pArgs); err != nil {
+		t.Fatal(err)
+	}
+	dArgs := deleteArgs(roachpb.Key("a"), 1, store.StoreID())
+	if _, err := client.SendWrapped(store, nil, &dArgs); err != nil {
+		t.Fatal(err)
+	}
+
+	e := <-c
+	if !e.Key.Equal(roachpb.Key("a")) || e.Value == nil || !bytes.Equal(e.Value.Bytes, []byte("value")) {
+		t.Errorf("expected put of a; got %s", e)
+	}
+	e = <-c
+	if !e.Key.Equal(roachpb.Key("a")) || e.Value != nil {
+		t.Errorf("expected deletion of a; got %s", e)
+	}
+
+	cancel()
+	for e := range c {
+		t.Errorf("unexpected event %s", e)
+	}
+}
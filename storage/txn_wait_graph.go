@@ -0,0 +1,96 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import "sync"
+
+// A txnWaitGraph records which transactions are waiting on which other
+// transactions to finish because of conflicting intents. Each waiting
+// request has a set of outgoing "waits-for" edges from its transaction,
+// which are replaced whenever it backs off again. Edges are kept per
+// request since a transaction may have several requests blocked at once,
+// and one of them finishing mustn't erase the others' edges. A cycle in
+// the graph means that its transactions are deadlocked: none of them can
+// make progress until one of them is aborted.
+//
+// Each store keeps its own graph, which only sees the waits of requests
+// executed by that store. A deadlock between transactions waiting on
+// intents on different stores is never detected; it is only broken by
+// priority and backoff, as without the graph.
+type txnWaitGraph struct {
+	mu         sync.Mutex
+	nextWaiter int64
+	// Pusher txn ID -> waiting request -> pushee txn IDs.
+	waitsFor map[string]map[int64][]string
+}
+
+func newTxnWaitGraph() *txnWaitGraph {
+	return &txnWaitGraph{waitsFor: map[string]map[int64][]string{}}
+}
+
+// newWaiter returns an ID identifying a request which may wait on
+// other transactions.
+func (g *txnWaitGraph) newWaiter() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nextWaiter++
+	return g.nextWaiter
+}
+
+// wait records that the given request of the pusher is waiting on the
+// given pushees, replacing any edges previously recorded for that
+// request. Returns true if one of the pushees is (transitively) waiting
+// on the pusher, i.e. if the new edges close a cycle.
+func (g *txnWaitGraph) wait(pusher string, waiter int64, pushees []string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	waiters, ok := g.waitsFor[pusher]
+	if !ok {
+		waiters = map[int64][]string{}
+		g.waitsFor[pusher] = waiters
+	}
+	waiters[waiter] = append([]string(nil), pushees...)
+
+	visited := map[string]struct{}{}
+	stack := append([]string(nil), pushees...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == pusher {
+			return true
+		}
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+		for _, ids := range g.waitsFor[id] {
+			stack = append(stack, ids...)
+		}
+	}
+	return false
+}
+
+// done removes the edges recorded for the given request of the pusher,
+// which is no longer waiting on anyone.
+func (g *txnWaitGraph) done(pusher string, waiter int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	waiters := g.waitsFor[pusher]
+	delete(waiters, waiter)
+	if len(waiters) == 0 {
+		delete(g.waitsFor, pusher)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/util/leaktest"
+)
+
+// TestTxnWaitGraph verifies that cycles are detected when waits-for
+// edges close them, and that finished waits no longer count.
+func TestTxnWaitGraph(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	g := newTxnWaitGraph()
+	wa, wb, wd := g.newWaiter(), g.newWaiter(), g.newWaiter()
+
+	if g.wait("a", wa, []string{"b"}) {
+		t.Fatal("unexpected cycle a->b")
+	}
+	if g.wait("b", wb, []string{"c", "d"}) {
+		t.Fatal("unexpected cycle b->c,d")
+	}
+	// a -> b -> d -> a.
+	if !g.wait("d", wd, []string{"a"}) {
+		t.Fatal("expected cycle a->b->d->a")
+	}
+	// Once b stops waiting, the cycle is broken.
+	g.done("b", wb)
+	if g.wait("d", wd, []string{"a"}) {
+		t.Fatal("unexpected cycle after b finished waiting")
+	}
+	// Replacing a's edges drops the old ones.
+	g.wait("b", wb, []string{"a"})
+	if g.wait("a", wa, []string{"c"}) {
+		t.Fatal("unexpected cycle after a's edges were replaced")
+	}
+	if !g.wait("a", wa, []string{"b"}) {
+		t.Fatal("expected cycle a->b->a")
+	}
+}
+
+// TestTxnWaitGraphConcurrentWaiters verifies that one request of a
+// transaction finishing doesn't erase the edges of another request of
+// the same transaction which is still waiting.
+func TestTxnWaitGraphConcurrentWaiters(t *testing.T) {
+	defer leaktest.AfterTest(t)
+	g := newTxnWaitGraph()
+	wa1, wa2, wb := g.newWaiter(), g.newWaiter(), g.newWaiter()
+
+	g.wait("a", wa1, []string{"c"})
+	g.wait("a", wa2, []string{"b"})
+	g.done("a", wa1)
+	if !g.wait("b", wb, []string{"a"}) {
+		t.Fatal("expected cycle a->b->a through a's remaining request")
+	}
+	g.done("a", wa2)
+	if g.wait("b", wb, []string{"a"}) {
+		t.Fatal("unexpected cycle after all of a's requests finished")
+	}
+}
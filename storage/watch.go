@@ -0,0 +1,209 @@
+// Copyright 2015 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/cockroachdb/cockroach/client"
+	"github.com/cockroachdb/cockroach/roachpb"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// watchBufferSize is the number of events buffered for each watcher.
+// A watcher which falls further behind is dropped.
+const watchBufferSize = 1024
+
+// A watcher receives the events for a span of keys.
+type watcher struct {
+	start, end roachpb.Key
+	c          chan client.WatchEvent
+}
+
+// A watchRegistry holds the watchers registered with a store, to which
+// the store's replicas publish the updates committed by the commands
+// they apply.
+type watchRegistry struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+func newWatchRegistry() *watchRegistry {
+	return &watchRegistry{watchers: map[*watcher]struct{}{}}
+}
+
+// register adds a watcher for the span [start,end).
+func (wr *watchRegistry) register(start, end roachpb.Key) *watcher {
+	w := &watcher{start: start, end: end, c: make(chan client.WatchEvent, watchBufferSize)}
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.watchers[w] = struct{}{}
+	return w
+}
+
+// unregister removes the watcher, closing its channel, unless it has
+// already been dropped.
+func (wr *watchRegistry) unregister(w *watcher) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if _, ok := wr.watchers[w]; ok {
+		delete(wr.watchers, w)
+		close(w.c)
+	}
+}
+
+// watching returns true if any watcher overlaps [key,endKey), or
+// contains key if endKey is nil.
+func (wr *watchRegistry) watching(key, endKey roachpb.Key) bool {
+	if wr == nil {
+		return false
+	}
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	for w := range wr.watchers {
+		if w.overlaps(key, endKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends the events to the watchers they concern. Watchers whose
+// buffer is full are dropped rather than waited for.
+func (wr *watchRegistry) publish(events []client.WatchEvent) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	for w := range wr.watchers {
+		for _, e := range events {
+			if !w.overlaps(e.Key, e.EndKey) {
+				continue
+			}
+			select {
+			case w.c <- e:
+				continue
+			default:
+			}
+			log.Warningf("dropping watcher of [%s,%s) which fell behind", w.start, w.end)
+			delete(wr.watchers, w)
+			close(w.c)
+			break
+		}
+	}
+}
+
+func (w *watcher) overlaps(key, endKey roachpb.Key) bool {
+	if endKey == nil {
+		return !key.Less(w.start) && key.Less(w.end)
+	}
+	return key.Less(w.end) && w.start.Less(endKey)
+}
+
+// Watch implements the client.Watcher interface. Only the updates
+// committed by the replicas of this store are returned.
+func (s *Store) Watch(ctx context.Context, start, end roachpb.Key) (<-chan client.WatchEvent, error) {
+	if !start.Less(end) {
+		return nil, util.Errorf("invalid watch span [%s,%s)", start, end)
+	}
+	w := s.watches.register(start, end)
+	s.stopper.RunWorker(func() {
+		select {
+		case <-ctx.Done():
+		case <-s.stopper.ShouldStop():
+		}
+		s.watches.unregister(w)
+	})
+	return w.c, nil
+}
+
+// publishWatchEvents publishes the updates committed by the given batch
+// to the store's watchers. It's called once the batch has been executed
+// by the given replica, which is the range's leader, rather than while
+// applying the batch on every replica, so that watchers see each update
+// once and the raft processing goroutine isn't slowed down by watchers.
+// Transactional writes are published when their intents are resolved as
+// committed, not when they're written.
+func (s *Store) publishWatchEvents(rng *Replica, ba *roachpb.BatchRequest, br *roachpb.BatchResponse) {
+	wr := s.watches
+	var events []client.WatchEvent
+	add := func(key, endKey roachpb.Key, ts roachpb.Timestamp) {
+		if !wr.watching(key, endKey) {
+			return
+		}
+		e := client.WatchEvent{Key: key, EndKey: endKey, Timestamp: ts}
+		if endKey == nil {
+			// Read back the value which was committed. Commands in a batch
+			// are executed at slightly different timestamps, so take the
+			// latest committed value, ignoring intents.
+			value, _, err := engine.MVCCGet(s.engine, key, roachpb.MaxTimestamp, false /* !consistent */, nil)
+			if err != nil {
+				log.Warningf("unable to read %s for watchers: %s", key, err)
+				return
+			}
+			e.Value = value
+			if value != nil && value.Timestamp != nil {
+				e.Timestamp = *value.Timestamp
+			}
+		}
+		events = append(events, e)
+	}
+
+	for i, union := range ba.Requests {
+		args := union.GetInner()
+		header := args.Header()
+		switch t := args.(type) {
+		case *roachpb.PutRequest, *roachpb.ConditionalPutRequest, *roachpb.IncrementRequest, *roachpb.DeleteRequest:
+			if ba.Txn == nil {
+				add(header.Key, nil, ba.Timestamp)
+			}
+		case *roachpb.DeleteRangeRequest:
+			if ba.Txn == nil {
+				add(header.Key, header.EndKey, ba.Timestamp)
+			}
+		case *roachpb.ResolveIntentRequest:
+			if t.IntentTxn.Status == roachpb.COMMITTED {
+				add(header.Key, nil, t.IntentTxn.Timestamp)
+			}
+		case *roachpb.ResolveIntentRangeRequest:
+			if t.IntentTxn.Status == roachpb.COMMITTED {
+				add(header.Key, header.EndKey, t.IntentTxn.Timestamp)
+			}
+		case *roachpb.EndTransactionRequest:
+			reply := br.Responses[i].GetInner().(*roachpb.EndTransactionResponse)
+			if reply.Txn == nil || reply.Txn.Status != roachpb.COMMITTED {
+				continue
+			}
+			// The intents on this range were resolved along with the
+			// commit; the others are published by their own ranges.
+			desc := *rng.Desc()
+			for _, intent := range t.Intents {
+				if len(intent.EndKey) == 0 {
+					if containsKey(desc, intent.Key) {
+						add(intent.Key, nil, reply.Txn.Timestamp)
+					}
+				} else if inside, _ := intersectIntent(intent, desc); inside != nil {
+					add(inside.Key, inside.EndKey, reply.Txn.Timestamp)
+				}
+			}
+		}
+	}
+	if len(events) > 0 {
+		wr.publish(events)
+	}
+}
@@ -22,6 +22,7 @@
 package hlc
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -231,3 +232,38 @@ func (c *Clock) Update(rt roachpb.Timestamp) roachpb.Timestamp {
 	}
 	return c.timestamp()
 }
+
+// WaitForTimestamp blocks until the physical clock has passed the wall
+// time of ts, which is usually the commit timestamp of a transaction.
+// Once it returns, every timestamp handed out by Now is later than ts,
+// and so is the physical time on any node whose clock is within the
+// maximum offset of ours: reads at the current time on those nodes
+// observe writes made at ts. The clock is also updated with ts right
+// away, so that events on this node which follow the call are ordered
+// after ts even while waiting.
+//
+// The wait is bounded by the maximum offset, if set. A timestamp
+// further ahead of the physical clock than that can't originate from a
+// node within the offset and is rejected with an error without
+// waiting. Returns immediately if the physical clock is already past
+// ts.
+func (c *Clock) WaitForTimestamp(ts roachpb.Timestamp) error {
+	now := c.PhysicalNow()
+	if maxOffset := c.MaxOffset(); maxOffset > 0 && ts.WallTime-now > maxOffset.Nanoseconds() {
+		return fmt.Errorf("timestamp %s is more than the maximum offset %s ahead of the local clock (%d)",
+			ts, maxOffset, now)
+	}
+	c.Update(ts)
+	for now <= ts.WallTime {
+		// Sleep for as long as the physical clock has yet to advance, which
+		// is exactly long enough unless the clock is manual or jumps, but
+		// no less than a millisecond to avoid spinning on such clocks.
+		wait := time.Duration(ts.WallTime - now + 1)
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		time.Sleep(wait)
+		now = c.PhysicalNow()
+	}
+	return nil
+}
@@ -19,6 +19,7 @@ package hlc
 
 import (
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -168,3 +169,52 @@ func ExampleManualClock() {
 		log.Fatalf("manual clock error")
 	}
 }
+
+// TestWaitForTimestamp verifies that WaitForTimestamp returns only once
+// the physical clock has passed the timestamp, without polling the
+// clock in a busy loop, and rejects timestamps beyond the maximum
+// offset.
+func TestWaitForTimestamp(t *testing.T) {
+	m := NewManualClock(100)
+	var reads int32
+	c := NewClock(func() int64 {
+		atomic.AddInt32(&reads, 1)
+		return m.UnixNano()
+	})
+	c.SetMaxOffset(time.Second)
+
+	// The clock is already past the timestamp.
+	if err := c.WaitForTimestamp(roachpb.Timestamp{WallTime: 50}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := roachpb.Timestamp{WallTime: int64(10 * time.Millisecond)}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.WaitForTimestamp(ts)
+	}()
+	for _, wallTime := range []int64{ts.WallTime / 2, ts.WallTime} {
+		m.Set(wallTime)
+		select {
+		case err := <-done:
+			t.Fatalf("expected wait to continue at %d; returned with %v", wallTime, err)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	if now := c.Timestamp(); !ts.Less(now) {
+		t.Errorf("expected clock to have been updated past %s while waiting; got %s", ts, now)
+	}
+	m.Set(ts.WallTime + 1)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// Each sleep lasts at least a millisecond, so the wait of about 40ms
+	// reads the clock no more than 40 times.
+	if n := atomic.LoadInt32(&reads); n > 50 {
+		t.Errorf("expected the clock to be polled sparingly; got %d reads", n)
+	}
+
+	if err := c.WaitForTimestamp(roachpb.Timestamp{WallTime: int64(2 * time.Second)}); err == nil {
+		t.Error("expected timestamp beyond the maximum offset to be rejected")
+	}
+}
@@ -27,12 +27,13 @@ import (
 
 // Options provides reusable configuration of Retry objects.
 type Options struct {
-	InitialBackoff      time.Duration // Default retry backoff interval
-	MaxBackoff          time.Duration // Maximum retry backoff interval
-	Multiplier          float64       // Default backoff constant
-	MaxRetries          int           // Maximum number of attempts (0 for infinite)
-	RandomizationFactor float64       // Randomize the backoff interval by constant
-	Stopper             *stop.Stopper // Optionally end retry loop on stopper signal
+	InitialBackoff      time.Duration   // Default retry backoff interval
+	MaxBackoff          time.Duration   // Maximum retry backoff interval
+	Multiplier          float64         // Default backoff constant
+	MaxRetries          int             // Maximum number of attempts (0 for infinite)
+	RandomizationFactor float64         // Randomize the backoff interval by constant
+	Stopper             *stop.Stopper   // Optionally end retry loop on stopper signal
+	Closer              <-chan struct{} // Optionally end retry loop on channel close
 }
 
 // Retry implements the public methods necessary to control an exponential-
@@ -112,5 +113,7 @@ func (r *Retry) Next() bool {
 		return true
 	case <-r.opts.Stopper.ShouldStop():
 		return false
+	case <-r.opts.Closer:
+		return false
 	}
 }
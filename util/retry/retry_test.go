@@ -93,6 +93,29 @@ func TestRetryReset(t *testing.T) {
 	}
 }
 
+func TestRetryRandomization(t *testing.T) {
+	opts := Options{
+		InitialBackoff:      time.Second,
+		MaxBackoff:          time.Second,
+		Multiplier:          1,
+		RandomizationFactor: 0.25,
+	}
+
+	r := Start(opts)
+	minBackoff, maxBackoff := 750*time.Millisecond, 1250*time.Millisecond
+	seen := map[time.Duration]struct{}{}
+	for i := 0; i < 100; i++ {
+		backoff := r.retryIn()
+		if backoff < minBackoff || backoff > maxBackoff {
+			t.Errorf("expected backoff in [%s,%s], got %s", minBackoff, maxBackoff, backoff)
+		}
+		seen[backoff] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Errorf("expected randomized backoffs, got %d distinct value(s)", len(seen))
+	}
+}
+
 func TestRetryStop(t *testing.T) {
 	opts := Options{
 		InitialBackoff: time.Second,
@@ -114,3 +137,24 @@ func TestRetryStop(t *testing.T) {
 		t.Errorf("expected %d attempts, got %d", expAttempts, attempts)
 	}
 }
+
+func TestRetryClose(t *testing.T) {
+	closer := make(chan struct{})
+	opts := Options{
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Closer:         closer,
+	}
+
+	var attempts int
+
+	// Create a retry loop which will never stop without the closer.
+	for r := Start(opts); r.Next(); attempts++ {
+		close(closer)
+	}
+
+	if expAttempts := 1; attempts != expAttempts {
+		t.Errorf("expected %d attempts, got %d", expAttempts, attempts)
+	}
+}